@@ -0,0 +1,347 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/executor"
+)
+
+// Prefix is the path under which the API is served
+const Prefix = "/api/v1"
+
+// TriggerPath is the path of the endpoint running a target immediately
+const TriggerPath = "/-/trigger"
+
+// triggerWaitSlack is added to the trace timeout when waiting for a
+// triggered run, which may have to wait for a free worker first
+const triggerWaitSlack = 5 * time.Second
+
+// API serves targets and their execution results as JSON
+type API struct {
+	executor *executor.Executor
+	targets  func() []config.Target
+	logger   *slog.Logger
+}
+
+// targetJSON is the JSON representation of a target and its latest status
+type targetJSON struct {
+	Name            string            `json:"name"`
+	Host            string            `json:"host"`
+	Protocol        string            `json:"protocol"`
+	Port            int               `json:"port,omitempty"`
+	Backend         string            `json:"backend"`
+	IntervalSeconds float64           `json:"interval_seconds"`
+	MaxHops         int               `json:"max_hops"`
+	Queries         int               `json:"queries,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	LastStatus      string            `json:"last_status,omitempty"`
+	LastReason      string            `json:"last_reason,omitempty"`
+	LastTimestamp   *time.Time        `json:"last_timestamp,omitempty"`
+	Failures        int               `json:"consecutive_failures,omitempty"`
+	BackoffSeconds  float64           `json:"backoff_seconds,omitempty"`
+	CircuitBreaker  string            `json:"circuit_breaker,omitempty"`
+}
+
+// eventsKeepAlive is the interval of comments sent on idle event streams so
+// proxies don't close them
+const eventsKeepAlive = 15 * time.Second
+
+// EventDropped is the type of the event telling a client that events were
+// dropped because it did not keep up
+const EventDropped = "events_dropped"
+
+// triggerJSON is the response to a trigger that does not wait for the run
+type triggerJSON struct {
+	Target string `json:"target"`
+	Status string `json:"status"` // "queued", or "running" if a run was already in flight
+}
+
+// errorJSON is the body of error responses
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// New creates an API serving the results of exec for the targets returned
+// by targets, which is called on every request to follow reloads
+func New(exec *executor.Executor, targets func() []config.Target, logger *slog.Logger) *API {
+	return &API{
+		executor: exec,
+		targets:  targets,
+		logger:   logger,
+	}
+}
+
+// Register adds the API endpoints to mux
+func (a *API) Register(mux *http.ServeMux) {
+	mux.HandleFunc(Prefix+"/targets", a.handleTargets)
+	mux.HandleFunc(Prefix+"/targets/", a.handleTarget)
+	mux.HandleFunc(Prefix+"/events", a.handleEvents)
+	mux.HandleFunc(TriggerPath, a.handleTrigger)
+}
+
+// handleTargets serves the list of targets
+func (a *API) handleTargets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	targets := a.targets()
+	out := make([]targetJSON, 0, len(targets))
+	for _, target := range targets {
+		out = append(out, a.targetJSON(target))
+	}
+	a.writeJSON(w, http.StatusOK, out)
+}
+
+// handleTarget serves /targets/{name}, /targets/{name}/latest and
+// /targets/{name}/history
+func (a *API) handleTarget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Split the escaped path so names may contain a slash
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), Prefix+"/targets/")
+	escapedName, resource, _ := strings.Cut(rest, "/")
+	name, err := url.PathUnescape(escapedName)
+	if err != nil || name == "" {
+		a.writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	target, exists := a.target(name)
+	if !exists {
+		a.writeError(w, http.StatusNotFound, "unknown target "+name)
+		return
+	}
+
+	switch resource {
+	case "":
+		a.writeJSON(w, http.StatusOK, a.targetJSON(target))
+	case "latest":
+		result, exists := a.executor.GetResult(name)
+		if !exists {
+			a.writeError(w, http.StatusNotFound, "no result yet for target "+name)
+			return
+		}
+		a.writeJSON(w, http.StatusOK, result)
+	case "history":
+		a.writeJSON(w, http.StatusOK, a.executor.GetHistory(name))
+	default:
+		a.writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// handleTrigger runs the target given by the target parameter now. With
+// wait=true it responds with the result of the run once it finished.
+func (a *API) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	params := r.URL.Query()
+	name := params.Get("target")
+	if name == "" {
+		a.writeError(w, http.StatusBadRequest, "missing 'target' parameter")
+		return
+	}
+
+	var wait bool
+	if value := params.Get("wait"); value != "" {
+		var err error
+		if wait, err = strconv.ParseBool(value); err != nil {
+			a.writeError(w, http.StatusBadRequest, "invalid 'wait' parameter "+value)
+			return
+		}
+	}
+
+	run, running, err := a.executor.TriggerTarget(name)
+	var (
+		rateErr    *executor.RateLimitError
+		breakerErr *executor.BreakerOpenError
+	)
+	switch {
+	case errors.Is(err, executor.ErrUnknownTarget):
+		a.writeError(w, http.StatusNotFound, "unknown target "+name)
+		return
+	case errors.As(err, &rateErr):
+		setRetryAfter(w, rateErr.RetryAfter)
+		a.writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case errors.As(err, &breakerErr):
+		setRetryAfter(w, breakerErr.RetryAfter)
+		a.writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		a.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !wait {
+		status := "queued"
+		if running {
+			status = "running"
+		}
+		a.writeJSON(w, http.StatusAccepted, triggerJSON{Target: name, Status: status})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.executor.Timeout()+triggerWaitSlack)
+	defer cancel()
+
+	select {
+	case <-run.Done():
+	case <-ctx.Done():
+		a.writeError(w, http.StatusGatewayTimeout, "timed out waiting for the run of target "+name)
+		return
+	}
+
+	// Runs cancelled by a stop or reload have no result
+	result := run.Result()
+	if result == nil {
+		a.writeError(w, http.StatusServiceUnavailable, "run of target "+name+" was cancelled")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, result)
+}
+
+// setRetryAfter sets the Retry-After header to d rounded up to seconds
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	seconds := (d + time.Second - 1) / time.Second
+	w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+}
+
+// handleEvents streams executor events as Server-Sent Events, limited to the
+// targets given by the target parameters if any
+func (a *API) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// The stream outlives the server write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		a.logger.Debug("Failed to clear write deadline of event stream", "error", err)
+	}
+
+	sub := a.executor.Subscribe(executor.DefaultEventBuffer, r.URL.Query()["target"]...)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		a.logger.Debug("Event stream not supported", "error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	var dropped uint64
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case event := <-sub.Events():
+			// Tell the client to catch up through the API if it missed events
+			if total := sub.Dropped(); total > dropped {
+				err = writeEvent(w, EventDropped, map[string]uint64{"dropped": total - dropped})
+				dropped = total
+			}
+			if err == nil {
+				err = writeEvent(w, event.Type, event)
+			}
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			a.logger.Debug("Closing event stream", "error", err)
+			return
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event with v as JSON data
+func writeEvent(w io.Writer, eventType string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data)
+	return err
+}
+
+// target returns the active target with the given name
+func (a *API) target(name string) (config.Target, bool) {
+	for _, target := range a.targets() {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return config.Target{}, false
+}
+
+// targetJSON builds the JSON representation of a target
+func (a *API) targetJSON(target config.Target) targetJSON {
+	out := targetJSON{
+		Name:            target.Name,
+		Host:            target.Host,
+		Protocol:        target.Protocol,
+		Port:            target.Port,
+		Backend:         target.Backend,
+		IntervalSeconds: target.Interval.Seconds(),
+		MaxHops:         target.MaxHops,
+		Queries:         target.Queries,
+		Labels:          target.Labels,
+	}
+	if result, exists := a.executor.GetResult(target.Name); exists {
+		out.LastStatus = result.Status
+		out.LastReason = result.Reason
+		out.LastTimestamp = &result.Timestamp
+	}
+	if state, exists := a.executor.GetBackoffState(target.Name); exists {
+		out.Failures = state.Failures
+		out.BackoffSeconds = state.Backoff.Seconds()
+		out.CircuitBreaker = state.Breaker
+	}
+	return out
+}
+
+// writeJSON writes v as the JSON response body
+func (a *API) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		a.logger.Debug("Failed to write API response", "error", err)
+	}
+}
+
+// writeError writes an error response
+func (a *API) writeError(w http.ResponseWriter, status int, message string) {
+	a.writeJSON(w, status, errorJSON{Error: message})
+}
@@ -0,0 +1,340 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/executor"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *executor.Executor) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	exec := executor.NewExecutor("nexttrace", time.Minute, logger)
+
+	targets := []config.Target{
+		{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP, Backend: config.BackendNextTrace, Interval: 5 * time.Minute, MaxHops: 30, Labels: map[string]string{"region": "us"}},
+		{Name: "dc/fra1", Host: "1.1.1.1", Protocol: config.ProtocolICMP, Backend: config.BackendNextTrace, Interval: time.Minute, MaxHops: 30},
+	}
+
+	mux := http.NewServeMux()
+	New(exec, func() []config.Target { return targets }, logger).Register(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, exec
+}
+
+// getJSON requests path and decodes the JSON response into v
+func getJSON(t *testing.T, server *httptest.Server, path string, v any) int {
+	t.Helper()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON content type, got %s", ct)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode response of %s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func TestTargets(t *testing.T) {
+	server, exec := newTestServer(t)
+	exec.SetTestFailure("google_dns", executor.StatusError, executor.ReasonDNSFailure)
+
+	var targets []targetJSON
+	if status := getJSON(t, server, "/api/v1/targets", &targets); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+
+	first := targets[0]
+	if first.Name != "google_dns" || first.IntervalSeconds != 300 || first.Labels["region"] != "us" {
+		t.Errorf("Unexpected target: %+v", first)
+	}
+	if first.LastStatus != executor.StatusError || first.LastReason != executor.ReasonDNSFailure || first.LastTimestamp == nil {
+		t.Errorf("Expected last error status, got %+v", first)
+	}
+	if targets[1].LastStatus != "" || targets[1].LastTimestamp != nil {
+		t.Errorf("Expected no status for target without results, got %+v", targets[1])
+	}
+}
+
+func TestTargetResults(t *testing.T) {
+	server, exec := newTestServer(t)
+
+	exec.SetTestFailure("google_dns", executor.StatusTimeout, executor.ReasonTimeout)
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{
+		Target: "8.8.8.8",
+		Hops:   []parser.Hop{{TTL: 1, IP: "192.168.1.1", RTT: []float64{1.5}}},
+	}, 2*time.Second)
+
+	var latest map[string]any
+	if status := getJSON(t, server, "/api/v1/targets/google_dns/latest", &latest); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if latest["status"] != executor.StatusSuccess || latest["duration_seconds"] != 2.0 {
+		t.Errorf("Unexpected latest result: %v", latest)
+	}
+	hops := latest["result"].(map[string]any)["hops"].([]any)
+	if len(hops) != 1 || hops[0].(map[string]any)["ip"] != "192.168.1.1" {
+		t.Errorf("Expected hop list in latest result, got %v", hops)
+	}
+
+	var history []map[string]any
+	if status := getJSON(t, server, "/api/v1/targets/google_dns/history", &history); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if len(history) != 2 || history[0]["reason"] != executor.ReasonTimeout || history[1]["status"] != executor.StatusSuccess {
+		t.Errorf("Unexpected history: %v", history)
+	}
+
+	// Escaped slashes are part of the name
+	var target targetJSON
+	if status := getJSON(t, server, "/api/v1/targets/dc%2Ffra1", &target); status != http.StatusOK || target.Name != "dc/fra1" {
+		t.Errorf("Expected target dc/fra1, got %d %+v", status, target)
+	}
+	if status := getJSON(t, server, "/api/v1/targets/dc%2Ffra1/history", &history); status != http.StatusOK || len(history) != 0 {
+		t.Errorf("Expected empty history, got %d %v", status, history)
+	}
+}
+
+func TestTargetErrors(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/api/v1/targets/unknown/latest", status: http.StatusNotFound},
+		{path: "/api/v1/targets/google_dns/latest", status: http.StatusNotFound}, // No result yet
+		{path: "/api/v1/targets/google_dns/other", status: http.StatusNotFound},
+		{path: "/api/v1/targets/", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var body errorJSON
+			if status := getJSON(t, server, tt.path, &body); status != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, status)
+			}
+			if body.Error == "" {
+				t.Error("Expected error message")
+			}
+		})
+	}
+
+	resp, err := http.Post(server.URL+"/api/v1/targets", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", resp.StatusCode)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	exec := executor.NewExecutor("nexttrace", time.Minute, logger)
+	exec.SetTriggerInterval(time.Hour)
+
+	// A missing binary fails right away, which is all a run needs here
+	targets := []config.Target{
+		{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP, Backend: config.BackendNextTrace, Binary: filepath.Join(t.TempDir(), "nexttrace"), Interval: time.Hour, MaxHops: 30},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exec.Start(ctx, targets)
+	defer exec.Stop()
+
+	mux := http.NewServeMux()
+	New(exec, func() []config.Target { return targets }, logger).Register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Let the first scheduled run finish
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, exists := exec.GetResult("google_dns"); exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected first run to finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	first, _ := exec.GetResult("google_dns")
+
+	post := func(query string, v any) *http.Response {
+		t.Helper()
+		resp, err := http.Post(server.URL+"/-/trigger?"+query, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode trigger response: %v", err)
+		}
+		return resp
+	}
+
+	var result map[string]any
+	if resp := post("target=google_dns&wait=true", &result); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", resp.StatusCode, result)
+	}
+	if result["reason"] != executor.ReasonBinaryNotFound {
+		t.Errorf("Expected result of the triggered run, got %v", result)
+	}
+	if latest, _ := exec.GetResult("google_dns"); latest == first {
+		t.Error("Expected triggered run to store a new result")
+	}
+	if history := exec.GetHistory("google_dns"); len(history) != 2 {
+		t.Errorf("Expected 2 runs in history, got %d", len(history))
+	}
+
+	var body errorJSON
+	resp := post("target=google_dns", &body)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected rate limited trigger with Retry-After, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{query: "target=unknown", status: http.StatusNotFound},
+		{query: "", status: http.StatusBadRequest},
+		{query: "target=google_dns&wait=maybe", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if resp := post(tt.query, &body); resp.StatusCode != tt.status || body.Error == "" {
+			t.Errorf("Query %q: expected status %d with error, got %d", tt.query, tt.status, resp.StatusCode)
+		}
+	}
+
+	var methodErr errorJSON
+	if status := getJSON(t, server, "/-/trigger?target=google_dns", &methodErr); status != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", status)
+	}
+}
+
+func TestTriggerPausedTarget(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	exec := executor.NewExecutor("nexttrace", time.Minute, logger)
+	exec.Configure(config.SchedulerConfig{
+		MaxConcurrentTraces: 1,
+		CircuitBreaker:      config.CircuitBreakerConfig{Threshold: 1, Cooldown: time.Hour, Reasons: []string{executor.ReasonBinaryNotFound}},
+	})
+
+	// A missing binary opens the breaker after the first run
+	targets := []config.Target{
+		{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP, Backend: config.BackendNextTrace, Binary: filepath.Join(t.TempDir(), "nexttrace"), Interval: time.Hour, MaxHops: 30},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exec.Start(ctx, targets)
+	defer exec.Stop()
+
+	mux := http.NewServeMux()
+	New(exec, func() []config.Target { return targets }, logger).Register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if state, _ := exec.GetBackoffState("google_dns"); state.Breaker == executor.BreakerOpen {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the circuit breaker to open")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	resp, err := http.Post(server.URL+"/-/trigger?target=google_dns", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body errorJSON
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode trigger response: %v", err)
+	}
+	if resp.StatusCode != http.StatusConflict || body.Error == "" {
+		t.Errorf("Expected status 409 with error, got %d", resp.StatusCode)
+	}
+	if retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After")); retryAfter < 3500 {
+		t.Errorf("Expected Retry-After until the cooldown ends, got %q", resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestEvents(t *testing.T) {
+	server, exec := newTestServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/events?target=google_dns", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected event stream, got %s", ct)
+	}
+
+	// The subscription exists once the headers are sent
+	exec.SetTestFailure("dc/fra1", executor.StatusError, executor.ReasonUnknown)
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{
+		Hops: []parser.Hop{{TTL: 1, IP: "192.168.1.1"}},
+	}, time.Second)
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if lines[0] != "event: "+executor.EventTraceFinished {
+		t.Errorf("Expected trace_finished event, got %q", lines[0])
+	}
+	var event executor.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &event); err != nil {
+		t.Fatalf("Failed to decode event data %q: %v", lines[1], err)
+	}
+	if event.Target != "google_dns" || event.Result == nil || event.Result.Status != executor.StatusSuccess {
+		t.Errorf("Expected finished event of google_dns only, got %+v", event)
+	}
+}
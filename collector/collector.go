@@ -2,198 +2,660 @@ package collector
 
 import (
 	"log/slog"
+	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vinsec/nexttrace_exporter/config"
 	"github.com/vinsec/nexttrace_exporter/executor"
+	"github.com/vinsec/nexttrace_exporter/parser"
 )
 
 // Collector implements the prometheus.Collector interface
 type Collector struct {
 	executor *executor.Executor
 	targets  []config.Target
+	mutex    sync.RWMutex
 	logger   *slog.Logger
 
+	// Custom target labels, exported on every per-target metric
+	labelNames []string
+
 	// Metric descriptors
 	hopRTT            *prometheus.Desc
+	hopRTTStat        *prometheus.Desc
 	hopLoss           *prometheus.Desc
+	hopForwardedLoss  *prometheus.Desc
+	hopRateLimited    *prometheus.Desc
+	hopResponders     *prometheus.Desc
+	hopGeo            *prometheus.Desc
+	responderRTT      *prometheus.Desc
+	responderShare    *prometheus.Desc
 	totalHops         *prometheus.Desc
+	trailingHops      *prometheus.Desc
+	destReached       *prometheus.Desc
+	destRTT           *prometheus.Desc
+	destLoss          *prometheus.Desc
 	executionDuration *prometheus.Desc
 	executionsTotal   *prometheus.Desc
+	errorsTotal       *prometheus.Desc
+	lastErrorInfo     *prometheus.Desc
 	lastExecution     *prometheus.Desc
+	lastAttempt       *prometheus.Desc
+	routeChanges      *prometheus.Desc
+	routeInfo         *prometheus.Desc
+	routeLastChange   *prometheus.Desc
+	schedulingLag     *prometheus.Desc
+	backoff           *prometheus.Desc
+	breakerState      *prometheus.Desc
+	queueDepth        *prometheus.Desc
+	runningTraces     *prometheus.Desc
+	maxConcurrent     *prometheus.Desc
 }
 
 // NewCollector creates a new Collector instance
 func NewCollector(exec *executor.Executor, targets []config.Target, logger *slog.Logger) *Collector {
-	return &Collector{
+	c := &Collector{
 		executor: exec,
-		targets:  targets,
 		logger:   logger,
 
-		hopRTT: prometheus.NewDesc(
-			"nexttrace_hop_rtt_milliseconds",
-			"Average RTT for each hop in milliseconds",
-			[]string{"target", "hop_number", "hop_ip", "hop_hostname", "hop_asn"},
+		queueDepth: prometheus.NewDesc(
+			"nexttrace_scheduler_queue_depth",
+			"Number of targets that are due but waiting for a free worker",
 			nil,
-		),
-
-		hopLoss: prometheus.NewDesc(
-			"nexttrace_hop_loss_ratio",
-			"Packet loss ratio for each hop (0-1)",
-			[]string{"target", "hop_number", "hop_ip"},
 			nil,
 		),
 
-		totalHops: prometheus.NewDesc(
-			"nexttrace_total_hops",
-			"Total number of hops to reach the target",
-			[]string{"target"},
+		runningTraces: prometheus.NewDesc(
+			"nexttrace_scheduler_running_traces",
+			"Number of nexttrace executions currently running",
 			nil,
-		),
-
-		executionDuration: prometheus.NewDesc(
-			"nexttrace_execution_duration_seconds",
-			"Duration of nexttrace command execution in seconds",
-			[]string{"target"},
 			nil,
 		),
 
-		executionsTotal: prometheus.NewDesc(
-			"nexttrace_executions_total",
-			"Total number of nexttrace executions",
-			[]string{"target", "status"},
+		maxConcurrent: prometheus.NewDesc(
+			"nexttrace_scheduler_max_concurrent_traces",
+			"Maximum number of concurrent nexttrace executions",
 			nil,
-		),
-
-		lastExecution: prometheus.NewDesc(
-			"nexttrace_last_execution_timestamp",
-			"Timestamp of the last successful execution",
-			[]string{"target"},
 			nil,
 		),
 	}
+	c.setTargets(targets)
+
+	return c
 }
 
-// Describe implements prometheus.Collector
-func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+// setTargets sets the target list and rebuilds the per-target descriptors,
+// whose label names include the custom labels of all targets
+func (c *Collector) setTargets(targets []config.Target) {
+	c.targets = targets
+	c.labelNames = customLabelNames(targets)
+
+	c.hopRTT = c.newDesc(
+		"nexttrace_hop_rtt_milliseconds",
+		"Average RTT for each hop in milliseconds",
+		"hop_number",
+		"hop_ip",
+		"hop_hostname",
+		"hop_asn",
+	)
+
+	c.hopRTTStat = c.newDesc(
+		"nexttrace_hop_rtt_stat_milliseconds",
+		"RTT statistics for each hop in milliseconds (stat: min, max, median, p90, stddev)",
+		"hop_number",
+		"hop_ip",
+		"stat",
+	)
+
+	c.hopLoss = c.newDesc(
+		"nexttrace_hop_loss_ratio",
+		"Packet loss ratio for each hop (0-1)",
+		"hop_number",
+		"hop_ip",
+	)
+
+	c.hopForwardedLoss = c.newDesc(
+		"nexttrace_hop_forwarded_loss_ratio",
+		"Packet loss that persists from each hop to the end of the trace (0-1), the lowest loss at the hop and all later hops",
+		"hop_number",
+		"hop_ip",
+	)
+
+	c.hopRateLimited = c.newDesc(
+		"nexttrace_hop_rate_limited",
+		"Whether the loss at a hop does not persist at later hops, which points to ICMP rate limiting (1) rather than forwarding loss (0)",
+		"hop_number",
+		"hop_ip",
+	)
+
+	c.hopResponders = c.newDesc(
+		"nexttrace_hop_responders",
+		"Number of distinct IPs that answered probes at each hop (ECMP fan-out)",
+		"hop_number",
+	)
+
+	c.hopGeo = c.newDesc(
+		"nexttrace_hop_geo_info",
+		"ASN and location of each hop, always 1",
+		"hop_number",
+		"hop_ip",
+		"hop_asn",
+		"as_name",
+		"country_code",
+		"location",
+		"latitude",
+		"longitude",
+	)
+
+	c.responderRTT = c.newDesc(
+		"nexttrace_hop_responder_rtt_milliseconds",
+		"Average RTT for each responder at a hop in milliseconds",
+		"hop_number",
+		"hop_ip",
+		"hop_hostname",
+		"hop_asn",
+	)
+
+	c.responderShare = c.newDesc(
+		"nexttrace_hop_responder_share_ratio",
+		"Fraction of probes at a hop answered by each responder (0-1)",
+		"hop_number",
+		"hop_ip",
+	)
+
+	c.totalHops = c.newDesc(
+		"nexttrace_total_hops",
+		"Total number of hops to reach the target",
+	)
+
+	c.trailingHops = c.newDesc(
+		"nexttrace_unresponsive_trailing_hops",
+		"Number of hops at the end of the trace where nothing answered",
+	)
+
+	c.destReached = c.newDesc(
+		"nexttrace_destination_reached",
+		"Whether the target address answered the trace (1) or not (0)",
+	)
+
+	c.destRTT = c.newDesc(
+		"nexttrace_destination_rtt_milliseconds",
+		"Average RTT of the target address in milliseconds",
+	)
+
+	c.destLoss = c.newDesc(
+		"nexttrace_destination_loss_ratio",
+		"Fraction of probes at the destination's hop not answered by the target address (0-1), 1 if it wasn't reached",
+	)
+
+	c.executionDuration = c.newDesc(
+		"nexttrace_execution_duration_seconds",
+		"Duration of nexttrace command execution in seconds",
+	)
+
+	c.executionsTotal = c.newDesc(
+		"nexttrace_executions_total",
+		"Total number of nexttrace executions",
+		"status",
+	)
+
+	c.errorsTotal = c.newDesc(
+		"nexttrace_errors_total",
+		"Total number of failed nexttrace executions by error reason",
+		"reason",
+	)
+
+	c.lastErrorInfo = c.newDesc(
+		"nexttrace_last_error_info",
+		"Reason of the last execution if it failed, always 1",
+		"reason",
+	)
+
+	c.lastExecution = c.newDesc(
+		"nexttrace_last_execution_timestamp",
+		"Timestamp of the last successful execution",
+	)
+
+	c.lastAttempt = c.newDesc(
+		"nexttrace_last_attempt_timestamp",
+		"Timestamp of the last execution attempt, regardless of status",
+	)
+
+	c.routeChanges = c.newDesc(
+		"nexttrace_route_changes_total",
+		"Total number of route changes detected between consecutive successful executions",
+	)
+
+	c.routeInfo = c.newDesc(
+		"nexttrace_route_info",
+		"Fingerprint of the current route, always 1",
+		"fingerprint",
+	)
+
+	c.routeLastChange = c.newDesc(
+		"nexttrace_route_last_change_timestamp",
+		"Timestamp of the last detected route change",
+	)
+
+	c.schedulingLag = c.newDesc(
+		"nexttrace_scheduling_lag_seconds",
+		"How late the last execution started compared to when it was due",
+	)
+
+	c.backoff = c.newDesc(
+		"nexttrace_target_backoff_seconds",
+		"Delay added to the next execution after consecutive failures",
+	)
+
+	c.breakerState = c.newDesc(
+		"nexttrace_target_circuit_breaker_state",
+		"Circuit breaker state of the target, 1 for the current state",
+		"state",
+	)
+}
+
+// Describe implements prometheus.Collector. It sends no descriptors, which
+// makes the collector unchecked: the custom target labels, and so the label
+// names of the per-target metrics, change when the targets are reloaded,
+// which a registry doesn't allow for the descriptors it checks.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {}
+
+// describe sends all descriptors, for collectors whose targets don't change
+func (c *Collector) describe(ch chan<- *prometheus.Desc) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	ch <- c.hopRTT
+	ch <- c.hopRTTStat
 	ch <- c.hopLoss
+	ch <- c.hopForwardedLoss
+	ch <- c.hopRateLimited
+	ch <- c.hopResponders
+	ch <- c.hopGeo
+	ch <- c.responderRTT
+	ch <- c.responderShare
 	ch <- c.totalHops
+	ch <- c.trailingHops
+	ch <- c.destReached
+	ch <- c.destRTT
+	ch <- c.destLoss
 	ch <- c.executionDuration
 	ch <- c.executionsTotal
+	ch <- c.errorsTotal
+	ch <- c.lastErrorInfo
 	ch <- c.lastExecution
+	ch <- c.lastAttempt
+	ch <- c.routeChanges
+	ch <- c.routeInfo
+	ch <- c.routeLastChange
+	ch <- c.schedulingLag
+	ch <- c.backoff
+	ch <- c.breakerState
+	ch <- c.queueDepth
+	ch <- c.runningTraces
+	ch <- c.maxConcurrent
 }
 
 // Collect implements prometheus.Collector
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	results := c.executor.GetAllResults()
 
-	for _, target := range c.targets {
-		result, exists := results[target.Name]
-		if !exists {
-			continue
-		}
+	// Scheduler state
+	stats := c.executor.SchedulerStats()
+	ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(stats.QueueDepth))
+	ch <- prometheus.MustNewConstMetric(c.runningTraces, prometheus.GaugeValue, float64(stats.Running))
+	ch <- prometheus.MustNewConstMetric(c.maxConcurrent, prometheus.GaugeValue, float64(stats.MaxConcurrent))
 
-		// Execution duration
-		ch <- prometheus.MustNewConstMetric(
-			c.executionDuration,
-			prometheus.GaugeValue,
-			result.Duration.Seconds(),
-			target.Name,
-		)
+	// The descriptors are rebuilt when targets change, so hold the lock
+	// for the whole collection
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 
-		// Execution count (we track cumulative in a simple way via the result status)
-		// Note: In a real implementation, you'd want to use actual counters
-		// For this exporter pattern, we'll emit the current state
-		if result.Status == "success" {
+	for _, target := range c.targets {
+		// Execution counters are exported for every status, starting at zero,
+		// so that increase() and rate() work from the first failure on
+		counts := c.executor.GetExecutionCounts(target.Name)
+		for _, status := range executor.Statuses {
 			ch <- prometheus.MustNewConstMetric(
 				c.executionsTotal,
 				prometheus.CounterValue,
-				1,
-				target.Name,
-				"success",
+				float64(counts[status]),
+				c.labelValues(target, status)...,
 			)
-		} else if result.Status == "error" {
+		}
+		errorCounts := c.executor.GetErrorCounts(target.Name)
+		for _, reason := range executor.Reasons {
 			ch <- prometheus.MustNewConstMetric(
-				c.executionsTotal,
+				c.errorsTotal,
 				prometheus.CounterValue,
-				1,
-				target.Name,
-				"error",
+				float64(errorCounts[reason]),
+				c.labelValues(target, reason)...,
 			)
-		} else if result.Status == "timeout" {
+		}
+
+		// Route change tracking
+		if route, exists := c.executor.GetRouteState(target.Name); exists {
 			ch <- prometheus.MustNewConstMetric(
-				c.executionsTotal,
+				c.routeChanges,
 				prometheus.CounterValue,
+				float64(route.Changes),
+				c.labelValues(target)...,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				c.routeInfo,
+				prometheus.GaugeValue,
 				1,
-				target.Name,
-				"timeout",
+				c.labelValues(target, route.Fingerprint)...,
 			)
+
+			if !route.LastChange.IsZero() {
+				ch <- prometheus.MustNewConstMetric(
+					c.routeLastChange,
+					prometheus.GaugeValue,
+					float64(route.LastChange.Unix()),
+					c.labelValues(target)...,
+				)
+			}
 		}
 
-		// Last execution timestamp
-		if result.Status == "success" {
+		if lag, exists := c.executor.GetSchedulingLag(target.Name); exists {
 			ch <- prometheus.MustNewConstMetric(
-				c.lastExecution,
+				c.schedulingLag,
 				prometheus.GaugeValue,
-				float64(result.Timestamp.Unix()),
-				target.Name,
+				lag.Seconds(),
+				c.labelValues(target)...,
 			)
 		}
 
-		// If execution was not successful, skip hop metrics
-		if result.Result == nil {
+		if state, exists := c.executor.GetBackoffState(target.Name); exists {
+			ch <- prometheus.MustNewConstMetric(
+				c.backoff,
+				prometheus.GaugeValue,
+				state.Backoff.Seconds(),
+				c.labelValues(target)...,
+			)
+			for _, breaker := range executor.BreakerStates {
+				value := 0.0
+				if breaker == state.Breaker {
+					value = 1
+				}
+				ch <- prometheus.MustNewConstMetric(
+					c.breakerState,
+					prometheus.GaugeValue,
+					value,
+					c.labelValues(target, breaker)...,
+				)
+			}
+		}
+
+		result, exists := results[target.Name]
+		if !exists {
 			continue
 		}
 
-		// Total hops
+		c.collectResult(ch, target, result)
+	}
+}
+
+// collectResult exports the metrics describing a single execution result
+func (c *Collector) collectResult(ch chan<- prometheus.Metric, target config.Target, result *executor.ExecutionResult) {
+	// Execution duration
+	ch <- prometheus.MustNewConstMetric(
+		c.executionDuration,
+		prometheus.GaugeValue,
+		result.Duration.Seconds(),
+		c.labelValues(target)...,
+	)
+
+	// Last attempt timestamp, regardless of status
+	ch <- prometheus.MustNewConstMetric(
+		c.lastAttempt,
+		prometheus.GaugeValue,
+		float64(result.Timestamp.Unix()),
+		c.labelValues(target)...,
+	)
+
+	// Last execution timestamp
+	if result.Status == executor.StatusSuccess {
+		ch <- prometheus.MustNewConstMetric(
+			c.lastExecution,
+			prometheus.GaugeValue,
+			float64(result.Timestamp.Unix()),
+			c.labelValues(target)...,
+		)
+	}
+
+	if result.Reason != "" {
+		ch <- prometheus.MustNewConstMetric(
+			c.lastErrorInfo,
+			prometheus.GaugeValue,
+			1,
+			c.labelValues(target, result.Reason)...,
+		)
+	}
+
+	// If execution was not successful, skip hop metrics
+	if result.Result == nil {
+		return
+	}
+
+	// Total hops
+	ch <- prometheus.MustNewConstMetric(
+		c.totalHops,
+		prometheus.GaugeValue,
+		float64(len(result.Result.Hops)),
+		c.labelValues(target)...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.trailingHops,
+		prometheus.GaugeValue,
+		float64(result.Result.UnresponsiveTrailingHops()),
+		c.labelValues(target)...,
+	)
+
+	// Destination metrics need the traced address, which is unknown when it
+	// couldn't be resolved
+	if result.Result.TargetIP != "" {
+		c.collectDestination(ch, target, result.Result)
+	}
+
+	// Per-hop metrics
+	forwardedLoss := result.Result.ForwardedLoss()
+	for i, hop := range result.Result.Hops {
+		hopNumber := formatHopNumber(hop.TTL)
+
+		// Responder count is exported for silent hops too, so a drop to zero is visible
 		ch <- prometheus.MustNewConstMetric(
-			c.totalHops,
+			c.hopResponders,
 			prometheus.GaugeValue,
-			float64(len(result.Result.Hops)),
-			target.Name,
+			float64(len(hop.Responders)),
+			c.labelValues(target, hopNumber)...,
 		)
 
-		// Per-hop metrics
-		for _, hop := range result.Result.Hops {
-			if !hop.HasValidIP() {
-				continue
+		if !hop.HasValidIP() {
+			continue
+		}
+
+		// Average RTT
+		avgRTT := hop.AverageRTT()
+		if avgRTT > 0 {
+			ch <- prometheus.MustNewConstMetric(
+				c.hopRTT,
+				prometheus.GaugeValue,
+				avgRTT,
+				c.labelValues(target, hopNumber, hop.IP, hop.Hostname, hop.ASN)...,
+			)
+		}
+
+		// RTT statistics. Percentiles need individual samples, which tools
+		// that only report aggregates don't provide
+		if hop.HasRTT() {
+			stats := []rttStat{
+				{"min", hop.MinRTT()},
+				{"max", hop.MaxRTT()},
+				{"stddev", hop.StdDevRTT()},
 			}
+			if len(hop.RTT) > 0 {
+				stats = append(stats,
+					rttStat{"median", hop.MedianRTT()},
+					rttStat{"p90", hop.PercentileRTT(90)},
+				)
+			}
+			for _, stat := range stats {
+				ch <- prometheus.MustNewConstMetric(
+					c.hopRTTStat,
+					prometheus.GaugeValue,
+					stat.value,
+					c.labelValues(target, hopNumber, hop.IP, stat.name)...,
+				)
+			}
+		}
+
+		// Packet loss
+		ch <- prometheus.MustNewConstMetric(
+			c.hopLoss,
+			prometheus.GaugeValue,
+			hop.Loss,
+			c.labelValues(target, hopNumber, hop.IP)...,
+		)
 
-			hopNumber := formatHopNumber(hop.TTL)
+		// Loss that doesn't carry over to later hops is rate limiting
+		rateLimited := 0.0
+		if hop.Loss > forwardedLoss[i] {
+			rateLimited = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.hopForwardedLoss,
+			prometheus.GaugeValue,
+			forwardedLoss[i],
+			c.labelValues(target, hopNumber, hop.IP)...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.hopRateLimited,
+			prometheus.GaugeValue,
+			rateLimited,
+			c.labelValues(target, hopNumber, hop.IP)...,
+		)
 
-			// Average RTT
-			avgRTT := hop.AverageRTT()
-			if avgRTT > 0 {
+		// ASN and location, from the trace backend or local databases
+		if hop.ASN != "" || hop.CountryCode != "" || hop.Location != "" {
+			var latitude, longitude string
+			if hop.Latitude != 0 || hop.Longitude != 0 {
+				latitude = strconv.FormatFloat(hop.Latitude, 'f', -1, 64)
+				longitude = strconv.FormatFloat(hop.Longitude, 'f', -1, 64)
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.hopGeo,
+				prometheus.GaugeValue,
+				1,
+				c.labelValues(target, hopNumber, hop.IP, hop.ASN, hop.ASName, hop.CountryCode, hop.Location, latitude, longitude)...,
+			)
+		}
+
+		// Per-responder metrics
+		for _, responder := range hop.Responders {
+			if rtt := responder.AverageRTT(); rtt > 0 {
 				ch <- prometheus.MustNewConstMetric(
-					c.hopRTT,
+					c.responderRTT,
 					prometheus.GaugeValue,
-					avgRTT,
-					target.Name,
-					hopNumber,
-					hop.IP,
-					hop.Hostname,
-					hop.ASN,
+					rtt,
+					c.labelValues(target, hopNumber, responder.IP, responder.Hostname, responder.ASN)...,
 				)
 			}
 
-			// Packet loss
 			ch <- prometheus.MustNewConstMetric(
-				c.hopLoss,
+				c.responderShare,
 				prometheus.GaugeValue,
-				hop.Loss,
-				target.Name,
-				hopNumber,
-				hop.IP,
+				responder.Share,
+				c.labelValues(target, hopNumber, responder.IP)...,
 			)
 		}
 	}
 }
 
+// collectDestination exports whether and how well the target address
+// answered a trace
+func (c *Collector) collectDestination(ch chan<- prometheus.Metric, target config.Target, result *parser.NextTraceResult) {
+	reached := 0.0
+	if _, _, found := result.Destination(); found {
+		reached = 1.0
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.destReached,
+		prometheus.GaugeValue,
+		reached,
+		c.labelValues(target)...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.destLoss,
+		prometheus.GaugeValue,
+		result.DestinationLoss(),
+		c.labelValues(target)...,
+	)
+
+	if rtt := result.DestinationRTT(); rtt > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			c.destRTT,
+			prometheus.GaugeValue,
+			rtt,
+			c.labelValues(target)...,
+		)
+	}
+}
+
 // UpdateTargets updates the target list for the collector
 func (c *Collector) UpdateTargets(targets []config.Target) {
-	c.targets = targets
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.setTargets(targets)
+}
+
+// newDesc creates a per-target descriptor. Its label names are "target" and
+// "protocol", the custom target labels, then the given metric labels.
+func (c *Collector) newDesc(name, help string, labels ...string) *prometheus.Desc {
+	names := append([]string{"target", "protocol"}, c.labelNames...)
+	return prometheus.NewDesc(name, help, append(names, labels...), nil)
+}
+
+// labelValues returns the label values for a descriptor created by newDesc.
+// Custom labels a target doesn't set are exported empty, which Prometheus
+// treats as absent.
+func (c *Collector) labelValues(target config.Target, values ...string) []string {
+	out := make([]string, 0, 2+len(c.labelNames)+len(values))
+	out = append(out, target.Name, target.Protocol)
+	for _, name := range c.labelNames {
+		out = append(out, target.Labels[name])
+	}
+	return append(out, values...)
+}
+
+// customLabelNames returns the sorted union of the custom label names of all
+// targets
+func customLabelNames(targets []config.Target) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, target := range targets {
+		for name := range target.Labels {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rttStat is one value of nexttrace_hop_rtt_stat_milliseconds
+type rttStat struct {
+	name  string
+	value float64
 }
 
 // formatHopNumber converts hop TTL to a string for use in labels
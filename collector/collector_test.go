@@ -0,0 +1,412 @@
+package collector
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/executor"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+func newTestCollector(targets []config.Target) (*Collector, *executor.Executor) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	exec := executor.NewExecutor("nexttrace", time.Minute, logger)
+	return NewCollector(exec, targets, logger), exec
+}
+
+func TestCollectExecutionsTotal(t *testing.T) {
+	targets := []config.Target{{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP}}
+	c, exec := newTestCollector(targets)
+
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{}, time.Second)
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{}, time.Second)
+
+	expected := `
+# HELP nexttrace_executions_total Total number of nexttrace executions
+# TYPE nexttrace_executions_total counter
+nexttrace_executions_total{protocol="icmp",status="error",target="google_dns"} 0
+nexttrace_executions_total{protocol="icmp",status="parse_error",target="google_dns"} 0
+nexttrace_executions_total{protocol="icmp",status="partial",target="google_dns"} 0
+nexttrace_executions_total{protocol="icmp",status="success",target="google_dns"} 2
+nexttrace_executions_total{protocol="icmp",status="timeout",target="google_dns"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "nexttrace_executions_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectHopResponders(t *testing.T) {
+	targets := []config.Target{{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP}}
+	c, exec := newTestCollector(targets)
+
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{
+		Hops: []parser.Hop{
+			{
+				TTL: 1,
+				IP:  "10.0.0.1",
+				RTT: []float64{1, 3},
+				Responders: []parser.Responder{
+					{IP: "10.0.0.1", RTT: []float64{1}, Share: 0.5},
+					{IP: "10.0.0.2", RTT: []float64{3}, Share: 0.5},
+				},
+			},
+			{TTL: 2, Loss: 1},
+		},
+	}, time.Second)
+
+	expected := `
+# HELP nexttrace_hop_responders Number of distinct IPs that answered probes at each hop (ECMP fan-out)
+# TYPE nexttrace_hop_responders gauge
+nexttrace_hop_responders{hop_number="1",protocol="icmp",target="google_dns"} 2
+nexttrace_hop_responders{hop_number="2",protocol="icmp",target="google_dns"} 0
+# HELP nexttrace_hop_responder_rtt_milliseconds Average RTT for each responder at a hop in milliseconds
+# TYPE nexttrace_hop_responder_rtt_milliseconds gauge
+nexttrace_hop_responder_rtt_milliseconds{hop_asn="",hop_hostname="",hop_ip="10.0.0.1",hop_number="1",protocol="icmp",target="google_dns"} 1
+nexttrace_hop_responder_rtt_milliseconds{hop_asn="",hop_hostname="",hop_ip="10.0.0.2",hop_number="1",protocol="icmp",target="google_dns"} 3
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nexttrace_hop_responders", "nexttrace_hop_responder_rtt_milliseconds"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProbeCollector(t *testing.T) {
+	target := config.DefaultModule.Target("8.8.8.8")
+	result := &executor.ExecutionResult{
+		Target:    target.Name,
+		Status:    executor.StatusSuccess,
+		Duration:  2 * time.Second,
+		Timestamp: time.Now(),
+		Result: &parser.NextTraceResult{
+			Hops: []parser.Hop{{TTL: 1, IP: "10.0.0.1", RTT: []float64{1}}},
+		},
+	}
+
+	expected := `
+# HELP nexttrace_probe_success Whether the on-demand trace succeeded (1) or not (0)
+# TYPE nexttrace_probe_success gauge
+nexttrace_probe_success{protocol="icmp",target="8.8.8.8"} 1
+# HELP nexttrace_total_hops Total number of hops to reach the target
+# TYPE nexttrace_total_hops gauge
+nexttrace_total_hops{protocol="icmp",target="8.8.8.8"} 1
+`
+	c := NewProbeCollector(target, result)
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nexttrace_probe_success", "nexttrace_total_hops"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectCustomLabels(t *testing.T) {
+	targets := []config.Target{
+		{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP, Labels: map[string]string{"region": "us", "team": "netops"}},
+		{Name: "quad9_dns", Host: "9.9.9.9", Protocol: config.ProtocolICMP, Labels: map[string]string{"region": "eu"}},
+	}
+	c, exec := newTestCollector(targets)
+
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{}, time.Second)
+	exec.SetTestResult("quad9_dns", &parser.NextTraceResult{}, time.Second)
+
+	expected := `
+# HELP nexttrace_total_hops Total number of hops to reach the target
+# TYPE nexttrace_total_hops gauge
+nexttrace_total_hops{protocol="icmp",region="us",target="google_dns",team="netops"} 0
+nexttrace_total_hops{protocol="icmp",region="eu",target="quad9_dns",team=""} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "nexttrace_total_hops"); err != nil {
+		t.Error(err)
+	}
+
+	// Label names follow the targets after an update
+	c.UpdateTargets(targets[1:])
+
+	expected = `
+# HELP nexttrace_total_hops Total number of hops to reach the target
+# TYPE nexttrace_total_hops gauge
+nexttrace_total_hops{protocol="icmp",region="eu",target="quad9_dns"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "nexttrace_total_hops"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectUpdateTargetLabels(t *testing.T) {
+	c, exec := newTestCollector([]config.Target{
+		{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP},
+	})
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{}, time.Second)
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatalf("Failed to register collector: %v", err)
+	}
+	if _, err := registry.Gather(); err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+
+	// A reload that adds custom labels changes the label names of every
+	// per-target metric
+	c.UpdateTargets([]config.Target{
+		{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP, Labels: map[string]string{"region": "us"}},
+	})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics after the reload: %v", err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "nexttrace_total_hops" {
+			continue
+		}
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			if label.GetName() == "region" && label.GetValue() == "us" {
+				return
+			}
+		}
+	}
+	t.Errorf("Expected nexttrace_total_hops with region=\"us\" after the reload")
+}
+
+func TestCollectHopGeo(t *testing.T) {
+	targets := []config.Target{{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP}}
+	c, exec := newTestCollector(targets)
+
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{
+		Hops: []parser.Hop{
+			{TTL: 1, IP: "192.168.1.1"},
+			{TTL: 2, IP: "8.8.8.8", ASN: "15169", ASName: "GOOGLE", CountryCode: "US", Location: "United States", Latitude: 37.751, Longitude: -97.822},
+			{TTL: 3, IP: "9.9.9.9", ASN: "19281"},
+		},
+	}, time.Second)
+
+	expected := `
+# HELP nexttrace_hop_geo_info ASN and location of each hop, always 1
+# TYPE nexttrace_hop_geo_info gauge
+nexttrace_hop_geo_info{as_name="GOOGLE",country_code="US",hop_asn="15169",hop_ip="8.8.8.8",hop_number="2",latitude="37.751",location="United States",longitude="-97.822",protocol="icmp",target="google_dns"} 1
+nexttrace_hop_geo_info{as_name="",country_code="",hop_asn="19281",hop_ip="9.9.9.9",hop_number="3",latitude="",location="",longitude="",protocol="icmp",target="google_dns"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "nexttrace_hop_geo_info"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectErrorReasons(t *testing.T) {
+	targets := []config.Target{{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP}}
+	c, exec := newTestCollector(targets)
+
+	exec.SetTestFailure("google_dns", executor.StatusError, executor.ReasonDNSFailure)
+	exec.SetTestFailure("google_dns", executor.StatusTimeout, executor.ReasonTimeout)
+
+	expected := `
+# HELP nexttrace_errors_total Total number of failed nexttrace executions by error reason
+# TYPE nexttrace_errors_total counter
+nexttrace_errors_total{protocol="icmp",reason="binary_not_found",target="google_dns"} 0
+nexttrace_errors_total{protocol="icmp",reason="dns_failure",target="google_dns"} 1
+nexttrace_errors_total{protocol="icmp",reason="network_unreachable",target="google_dns"} 0
+nexttrace_errors_total{protocol="icmp",reason="parse_error",target="google_dns"} 0
+nexttrace_errors_total{protocol="icmp",reason="permission_denied",target="google_dns"} 0
+nexttrace_errors_total{protocol="icmp",reason="timeout",target="google_dns"} 1
+nexttrace_errors_total{protocol="icmp",reason="unknown",target="google_dns"} 0
+# HELP nexttrace_last_error_info Reason of the last execution if it failed, always 1
+# TYPE nexttrace_last_error_info gauge
+nexttrace_last_error_info{protocol="icmp",reason="timeout",target="google_dns"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nexttrace_errors_total", "nexttrace_last_error_info"); err != nil {
+		t.Error(err)
+	}
+
+	// A successful execution clears the last error
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{}, time.Second)
+	if n := testutil.CollectAndCount(c, "nexttrace_last_error_info"); n != 0 {
+		t.Errorf("Expected no last error after success, got %d series", n)
+	}
+}
+
+func TestCollectDestination(t *testing.T) {
+	targets := []config.Target{
+		{Name: "reached", Host: "8.8.8.8", Protocol: config.ProtocolICMP},
+		{Name: "unreached", Host: "9.9.9.9", Protocol: config.ProtocolICMP},
+		{Name: "unresolved", Host: "example.invalid", Protocol: config.ProtocolICMP},
+	}
+	c, exec := newTestCollector(targets)
+
+	exec.SetTestResult("reached", &parser.NextTraceResult{
+		TargetIP: "8.8.8.8",
+		Hops: []parser.Hop{
+			parser.NewHop([]parser.Probe{{TTL: 1, Success: true, IP: "192.168.1.1", RTT: 1}}),
+			parser.NewHop([]parser.Probe{{TTL: 2, Success: true, IP: "8.8.8.8", RTT: 10}, {TTL: 2}}),
+		},
+	}, time.Second)
+	exec.SetTestResult("unreached", &parser.NextTraceResult{
+		TargetIP: "9.9.9.9",
+		Hops: []parser.Hop{
+			parser.NewHop([]parser.Probe{{TTL: 1, Success: true, IP: "192.168.1.1", RTT: 1}}),
+			parser.NewHop([]parser.Probe{{TTL: 2}}),
+			parser.NewHop([]parser.Probe{{TTL: 3}}),
+		},
+	}, time.Second)
+	exec.SetTestResult("unresolved", &parser.NextTraceResult{
+		Hops: []parser.Hop{parser.NewHop([]parser.Probe{{TTL: 1}})},
+	}, time.Second)
+
+	expected := `
+# HELP nexttrace_destination_loss_ratio Fraction of probes at the destination's hop not answered by the target address (0-1), 1 if it wasn't reached
+# TYPE nexttrace_destination_loss_ratio gauge
+nexttrace_destination_loss_ratio{protocol="icmp",target="reached"} 0.5
+nexttrace_destination_loss_ratio{protocol="icmp",target="unreached"} 1
+# HELP nexttrace_destination_reached Whether the target address answered the trace (1) or not (0)
+# TYPE nexttrace_destination_reached gauge
+nexttrace_destination_reached{protocol="icmp",target="reached"} 1
+nexttrace_destination_reached{protocol="icmp",target="unreached"} 0
+# HELP nexttrace_destination_rtt_milliseconds Average RTT of the target address in milliseconds
+# TYPE nexttrace_destination_rtt_milliseconds gauge
+nexttrace_destination_rtt_milliseconds{protocol="icmp",target="reached"} 10
+# HELP nexttrace_unresponsive_trailing_hops Number of hops at the end of the trace where nothing answered
+# TYPE nexttrace_unresponsive_trailing_hops gauge
+nexttrace_unresponsive_trailing_hops{protocol="icmp",target="reached"} 0
+nexttrace_unresponsive_trailing_hops{protocol="icmp",target="unreached"} 2
+nexttrace_unresponsive_trailing_hops{protocol="icmp",target="unresolved"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nexttrace_destination_reached", "nexttrace_destination_rtt_milliseconds",
+		"nexttrace_destination_loss_ratio", "nexttrace_unresponsive_trailing_hops"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectForwardedLoss(t *testing.T) {
+	targets := []config.Target{{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP}}
+	c, exec := newTestCollector(targets)
+
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{
+		Hops: []parser.Hop{
+			{TTL: 1, IP: "192.168.1.1", Loss: 0},
+			{TTL: 2, IP: "10.0.0.1", Loss: 0.5},
+			{TTL: 3, IP: "10.0.1.1", Loss: 0.25},
+			{TTL: 4, IP: "8.8.8.8", Loss: 0.25},
+		},
+	}, time.Second)
+
+	expected := `
+# HELP nexttrace_hop_forwarded_loss_ratio Packet loss that persists from each hop to the end of the trace (0-1), the lowest loss at the hop and all later hops
+# TYPE nexttrace_hop_forwarded_loss_ratio gauge
+nexttrace_hop_forwarded_loss_ratio{hop_ip="192.168.1.1",hop_number="1",protocol="icmp",target="google_dns"} 0
+nexttrace_hop_forwarded_loss_ratio{hop_ip="10.0.0.1",hop_number="2",protocol="icmp",target="google_dns"} 0.25
+nexttrace_hop_forwarded_loss_ratio{hop_ip="10.0.1.1",hop_number="3",protocol="icmp",target="google_dns"} 0.25
+nexttrace_hop_forwarded_loss_ratio{hop_ip="8.8.8.8",hop_number="4",protocol="icmp",target="google_dns"} 0.25
+# HELP nexttrace_hop_rate_limited Whether the loss at a hop does not persist at later hops, which points to ICMP rate limiting (1) rather than forwarding loss (0)
+# TYPE nexttrace_hop_rate_limited gauge
+nexttrace_hop_rate_limited{hop_ip="192.168.1.1",hop_number="1",protocol="icmp",target="google_dns"} 0
+nexttrace_hop_rate_limited{hop_ip="10.0.0.1",hop_number="2",protocol="icmp",target="google_dns"} 1
+nexttrace_hop_rate_limited{hop_ip="10.0.1.1",hop_number="3",protocol="icmp",target="google_dns"} 0
+nexttrace_hop_rate_limited{hop_ip="8.8.8.8",hop_number="4",protocol="icmp",target="google_dns"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nexttrace_hop_forwarded_loss_ratio", "nexttrace_hop_rate_limited"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectPartial(t *testing.T) {
+	targets := []config.Target{{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP}}
+	c, exec := newTestCollector(targets)
+
+	exec.SetTestPartial("google_dns", &parser.NextTraceResult{
+		TargetIP: "8.8.8.8",
+		Hops: []parser.Hop{
+			parser.NewHop([]parser.Probe{{TTL: 1, Success: true, IP: "192.168.1.1", RTT: 1}}),
+			parser.NewHop([]parser.Probe{{TTL: 2, Success: true, IP: "10.0.0.1", RTT: 5}}),
+		},
+	}, executor.ReasonTimeout)
+
+	// The completed hops are exported along with the reason the trace ended,
+	// which counts towards the errors of that reason
+	expected := `
+# HELP nexttrace_destination_reached Whether the target address answered the trace (1) or not (0)
+# TYPE nexttrace_destination_reached gauge
+nexttrace_destination_reached{protocol="icmp",target="google_dns"} 0
+# HELP nexttrace_errors_total Total number of failed nexttrace executions by error reason
+# TYPE nexttrace_errors_total counter
+nexttrace_errors_total{protocol="icmp",reason="binary_not_found",target="google_dns"} 0
+nexttrace_errors_total{protocol="icmp",reason="dns_failure",target="google_dns"} 0
+nexttrace_errors_total{protocol="icmp",reason="network_unreachable",target="google_dns"} 0
+nexttrace_errors_total{protocol="icmp",reason="parse_error",target="google_dns"} 0
+nexttrace_errors_total{protocol="icmp",reason="permission_denied",target="google_dns"} 0
+nexttrace_errors_total{protocol="icmp",reason="timeout",target="google_dns"} 1
+nexttrace_errors_total{protocol="icmp",reason="unknown",target="google_dns"} 0
+# HELP nexttrace_executions_total Total number of nexttrace executions
+# TYPE nexttrace_executions_total counter
+nexttrace_executions_total{protocol="icmp",status="error",target="google_dns"} 0
+nexttrace_executions_total{protocol="icmp",status="parse_error",target="google_dns"} 0
+nexttrace_executions_total{protocol="icmp",status="partial",target="google_dns"} 1
+nexttrace_executions_total{protocol="icmp",status="success",target="google_dns"} 0
+nexttrace_executions_total{protocol="icmp",status="timeout",target="google_dns"} 0
+# HELP nexttrace_last_error_info Reason of the last execution if it failed, always 1
+# TYPE nexttrace_last_error_info gauge
+nexttrace_last_error_info{protocol="icmp",reason="timeout",target="google_dns"} 1
+# HELP nexttrace_total_hops Total number of hops to reach the target
+# TYPE nexttrace_total_hops gauge
+nexttrace_total_hops{protocol="icmp",target="google_dns"} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nexttrace_destination_reached", "nexttrace_errors_total", "nexttrace_executions_total",
+		"nexttrace_last_error_info", "nexttrace_total_hops"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c, "nexttrace_hop_rtt_milliseconds"); n != 2 {
+		t.Errorf("Expected RTT of the 2 completed hops, got %d series", n)
+	}
+}
+
+func TestCollectBackoff(t *testing.T) {
+	targets := []config.Target{{
+		Name:     "google_dns",
+		Host:     "8.8.8.8",
+		Protocol: config.ProtocolICMP,
+		Interval: time.Hour,
+		Binary:   filepath.Join(t.TempDir(), "missing"),
+	}}
+	c, exec := newTestCollector(targets)
+	exec.Configure(config.SchedulerConfig{
+		Backoff:        config.BackoffConfig{Initial: time.Minute, Max: time.Hour},
+		CircuitBreaker: config.CircuitBreakerConfig{Threshold: 1, Cooldown: time.Hour, Reasons: []string{executor.ReasonBinaryNotFound}},
+	})
+	exec.Start(context.Background(), targets)
+	defer exec.Stop()
+
+	// The missing binary fails the first run right away
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if state, _ := exec.GetBackoffState("google_dns"); state.Failures == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the first run to fail")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	expected := `
+# HELP nexttrace_target_backoff_seconds Delay added to the next execution after consecutive failures
+# TYPE nexttrace_target_backoff_seconds gauge
+nexttrace_target_backoff_seconds{protocol="icmp",target="google_dns"} 60
+# HELP nexttrace_target_circuit_breaker_state Circuit breaker state of the target, 1 for the current state
+# TYPE nexttrace_target_circuit_breaker_state gauge
+nexttrace_target_circuit_breaker_state{protocol="icmp",state="closed",target="google_dns"} 0
+nexttrace_target_circuit_breaker_state{protocol="icmp",state="half_open",target="google_dns"} 0
+nexttrace_target_circuit_breaker_state{protocol="icmp",state="open",target="google_dns"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nexttrace_target_backoff_seconds", "nexttrace_target_circuit_breaker_state"); err != nil {
+		t.Error(err)
+	}
+}
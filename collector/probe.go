@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/executor"
+)
+
+// ProbeCollector exports the metrics of a single on-demand trace run
+// through the /probe endpoint
+type ProbeCollector struct {
+	*Collector
+	target config.Target
+	result *executor.ExecutionResult
+
+	probeSuccess *prometheus.Desc
+}
+
+// NewProbeCollector creates a collector for one execution result
+func NewProbeCollector(target config.Target, result *executor.ExecutionResult) *ProbeCollector {
+	p := &ProbeCollector{
+		Collector: NewCollector(nil, []config.Target{target}, nil),
+		target:    target,
+		result:    result,
+	}
+	p.probeSuccess = p.newDesc(
+		"nexttrace_probe_success",
+		"Whether the on-demand trace succeeded (1) or not (0)",
+	)
+
+	return p
+}
+
+// Describe implements prometheus.Collector. The target of a probe never
+// changes, so unlike Collector it sends all descriptors.
+func (p *ProbeCollector) Describe(ch chan<- *prometheus.Desc) {
+	p.Collector.describe(ch)
+	ch <- p.probeSuccess
+}
+
+// Collect implements prometheus.Collector
+func (p *ProbeCollector) Collect(ch chan<- prometheus.Metric) {
+	success := 0.0
+	if p.result.Status == executor.StatusSuccess {
+		success = 1.0
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		p.probeSuccess,
+		prometheus.GaugeValue,
+		success,
+		p.labelValues(p.target)...,
+	)
+
+	p.collectResult(ch, p.target, p.result)
+}
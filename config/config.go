@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -10,8 +13,13 @@ import (
 
 // Config represents the main configuration structure
 type Config struct {
-	Server  ServerConfig `yaml:"server"`
-	Targets []Target     `yaml:"targets"`
+	Server       ServerConfig      `yaml:"server"`
+	Scheduler    SchedulerConfig   `yaml:"scheduler"`
+	Targets      []Target          `yaml:"targets"`
+	TargetGroups []TargetGroup     `yaml:"target_groups"`
+	TargetFiles  []string          `yaml:"target_files"`
+	Modules      map[string]Module `yaml:"modules"`
+	Enrichment   EnrichmentConfig  `yaml:"enrichment"`
 }
 
 // ServerConfig represents the HTTP server configuration
@@ -20,21 +28,213 @@ type ServerConfig struct {
 	MetricsPath   string `yaml:"metrics_path"`
 }
 
+// SchedulerConfig controls how target executions are spread over time
+type SchedulerConfig struct {
+	// MaxConcurrentTraces limits how many nexttrace processes run at once
+	MaxConcurrentTraces int `yaml:"max_concurrent_traces"`
+	// Splay spreads the first run of each target randomly over this window
+	Splay time.Duration `yaml:"splay"`
+	// Jitter delays each run randomly by up to this fraction of the interval
+	Jitter float64 `yaml:"jitter"`
+	// Backoff delays the runs of a target that keeps failing
+	Backoff BackoffConfig `yaml:"backoff"`
+	// CircuitBreaker pauses a target that keeps failing for reasons that
+	// retrying won't fix
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// BackoffConfig controls the delay added to the next run of a target after
+// consecutive failed runs
+type BackoffConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// Initial is the delay after the first failure, doubled with each
+	// further consecutive failure
+	Initial time.Duration `yaml:"initial"`
+	// Max caps the delay
+	Max time.Duration `yaml:"max"`
+}
+
+// CircuitBreakerConfig controls when a target is paused. After Threshold
+// consecutive failures with one of Reasons the target is not run for
+// Cooldown; a single run then decides whether it resumes or pauses again.
+type CircuitBreakerConfig struct {
+	Disabled  bool          `yaml:"disabled"`
+	Threshold int           `yaml:"threshold"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+	Reasons   []string      `yaml:"reasons"` // Error reasons as in nexttrace_errors_total
+}
+
+// EnrichmentConfig configures offline ASN and geo data for hops, read from
+// local databases instead of the nexttrace API
+type EnrichmentConfig struct {
+	// MMDBFiles are MaxMind format databases such as GeoLite2-ASN and
+	// GeoLite2-City, queried in order
+	MMDBFiles []string `yaml:"mmdb_files"`
+	// IP2ASNFile is an ip2asn TSV file (ip2asn-combined.tsv), optionally gzipped
+	IP2ASNFile string `yaml:"ip2asn_file"`
+	// Override replaces data reported by the trace backend instead of only
+	// filling in what is missing
+	Override bool `yaml:"override"`
+}
+
+// DefaultMaxConcurrentTraces is the concurrency limit when none is configured
+const DefaultMaxConcurrentTraces = 10
+
+// Backoff and circuit breaker defaults
+const (
+	DefaultBackoffInitial          = 30 * time.Second
+	DefaultBackoffMax              = 30 * time.Minute
+	DefaultCircuitBreakerThreshold = 5
+	DefaultCircuitBreakerCooldown  = time.Hour
+)
+
+// ErrorReasons are the reasons an execution can fail with, which the
+// circuit breaker can be configured with. They match executor.Reasons.
+var ErrorReasons = []string{
+	"timeout",
+	"binary_not_found",
+	"permission_denied",
+	"dns_failure",
+	"network_unreachable",
+	"parse_error",
+	"unknown",
+}
+
+// DefaultCircuitBreakerReasons are the error reasons that open the circuit
+// breaker when none are configured: failures that persist until someone
+// fixes the configuration or the host
+var DefaultCircuitBreakerReasons = []string{"binary_not_found", "permission_denied", "dns_failure"}
+
 // Target represents a single nexttrace target configuration
 type Target struct {
-	Host     string        `yaml:"host"`
-	Name     string        `yaml:"name"`
-	Interval time.Duration `yaml:"interval"`
-	MaxHops  int           `yaml:"max_hops"`
+	Host     string            `yaml:"host"`
+	Name     string            `yaml:"name"`
+	Interval time.Duration     `yaml:"interval"`
+	MaxHops  int               `yaml:"max_hops"`
+	Protocol string            `yaml:"protocol"`
+	Port     int               `yaml:"port"`
+	Queries  int               `yaml:"queries"` // Probes per hop, 0 uses the nexttrace default
+	Backend  string            `yaml:"backend"`
+	Binary   string            `yaml:"binary"` // Overrides the backend's default binary
+	Labels   map[string]string `yaml:"labels"`
+}
+
+// TargetGroup holds targets that share labels and default options. Options
+// set on a target take precedence over the group's, and target labels are
+// merged over the group labels.
+type TargetGroup struct {
+	Interval time.Duration     `yaml:"interval"`
+	MaxHops  int               `yaml:"max_hops"`
+	Protocol string            `yaml:"protocol"`
+	Port     int               `yaml:"port"`
+	Queries  int               `yaml:"queries"`
+	Backend  string            `yaml:"backend"`
+	Binary   string            `yaml:"binary"`
+	Labels   map[string]string `yaml:"labels"`
+	Targets  []Target          `yaml:"targets"`
+}
+
+// BuiltinLabels are the label names used by the exporter's own metrics,
+// which custom target labels must not override
+var BuiltinLabels = []string{
+	"target",
+	"protocol",
+	"hop_number",
+	"hop_ip",
+	"hop_hostname",
+	"hop_asn",
+	"as_name",
+	"country_code",
+	"location",
+	"latitude",
+	"longitude",
+	"stat",
+	"status",
+	"reason",
+	"fingerprint",
+	"state",
+}
+
+// labelNameRE matches valid Prometheus label names
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Module represents a named set of probe settings for on-demand traces
+// requested through the /probe endpoint
+type Module struct {
+	MaxHops  int    `yaml:"max_hops"`
+	Protocol string `yaml:"protocol"`
+	Port     int    `yaml:"port"`
+	Queries  int    `yaml:"queries"`
+	Backend  string `yaml:"backend"`
+	Binary   string `yaml:"binary"`
+}
+
+// DefaultModuleName is the module used by /probe when none is requested
+const DefaultModuleName = "default"
+
+// DefaultModule holds the probe settings used when no module is requested
+// and no module named "default" is configured
+var DefaultModule = Module{
+	MaxHops:  30,
+	Protocol: ProtocolICMP,
+	Backend:  BackendNextTrace,
+}
+
+// Target builds an on-demand target for host using the module settings
+func (m Module) Target(host string) Target {
+	return Target{
+		Host:     host,
+		Name:     host,
+		MaxHops:  m.MaxHops,
+		Protocol: m.Protocol,
+		Port:     m.Port,
+		Queries:  m.Queries,
+		Backend:  m.Backend,
+		Binary:   m.Binary,
+	}
+}
+
+// MaxQueries is the largest number of probes per hop a target may request
+const MaxQueries = 20
+
+// Supported probe protocols
+const (
+	ProtocolICMP = "icmp"
+	ProtocolTCP  = "tcp"
+	ProtocolUDP  = "udp"
+)
+
+// Supported trace backends
+const (
+	BackendNextTrace  = "nexttrace"  // Runs the nexttrace binary
+	BackendMTR        = "mtr"        // Runs mtr --json
+	BackendTraceroute = "traceroute" // Runs the classic traceroute command
+	BackendNative     = "native"     // Built-in traceroute, no external binary needed
+)
+
+// defaultProtocol returns the probe protocol of a backend when none is set:
+// ICMP, which is what nexttrace does without flags, except for traceroute,
+// whose ICMP mode needs root while its default UDP probes don't
+func defaultProtocol(backend string) string {
+	if backend == BackendTraceroute {
+		return ProtocolUDP
+	}
+	return ProtocolICMP
 }
 
 // UnmarshalYAML implements custom unmarshaling for Target to handle duration parsing
 func (t *Target) UnmarshalYAML(value *yaml.Node) error {
 	type rawTarget struct {
-		Host     string `yaml:"host"`
-		Name     string `yaml:"name"`
-		Interval string `yaml:"interval"`
-		MaxHops  int    `yaml:"max_hops"`
+		Host     string            `yaml:"host"`
+		Name     string            `yaml:"name"`
+		Interval string            `yaml:"interval"`
+		MaxHops  int               `yaml:"max_hops"`
+		Protocol string            `yaml:"protocol"`
+		Port     int               `yaml:"port"`
+		Queries  int               `yaml:"queries"`
+		Backend  string            `yaml:"backend"`
+		Binary   string            `yaml:"binary"`
+		Labels   map[string]string `yaml:"labels"`
 	}
 
 	var raw rawTarget
@@ -45,11 +245,15 @@ func (t *Target) UnmarshalYAML(value *yaml.Node) error {
 	t.Host = raw.Host
 	t.Name = raw.Name
 	t.MaxHops = raw.MaxHops
+	t.Protocol = strings.ToLower(raw.Protocol)
+	t.Port = raw.Port
+	t.Queries = raw.Queries
+	t.Backend = strings.ToLower(raw.Backend)
+	t.Binary = raw.Binary
+	t.Labels = raw.Labels
 
 	// Parse interval
-	if raw.Interval == "" {
-		t.Interval = 5 * time.Minute // Default interval
-	} else {
+	if raw.Interval != "" {
 		duration, err := time.ParseDuration(raw.Interval)
 		if err != nil {
 			return fmt.Errorf("invalid interval format for target %s: %w", raw.Host, err)
@@ -57,17 +261,32 @@ func (t *Target) UnmarshalYAML(value *yaml.Node) error {
 		t.Interval = duration
 	}
 
+	return nil
+}
+
+// setDefaults fills in the defaults for options that are not set
+func (t *Target) setDefaults() {
+	if t.Interval == 0 {
+		t.Interval = 5 * time.Minute // Default interval
+	}
+
 	// Set default max hops if not specified
 	if t.MaxHops == 0 {
 		t.MaxHops = 30
 	}
 
+	if t.Backend == "" {
+		t.Backend = BackendNextTrace
+	}
+
+	if t.Protocol == "" {
+		t.Protocol = defaultProtocol(t.Backend)
+	}
+
 	// Set default name if not specified
 	if t.Name == "" {
 		t.Name = t.Host
 	}
-
-	return nil
 }
 
 // LoadConfig loads and parses the configuration file
@@ -82,6 +301,17 @@ func LoadConfig(filename string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	// Target file patterns are relative to the config file, like in Prometheus
+	for i, pattern := range config.TargetFiles {
+		config.TargetFiles[i] = resolvePath(filename, pattern)
+	}
+	for i, path := range config.Enrichment.MMDBFiles {
+		config.Enrichment.MMDBFiles[i] = resolvePath(filename, path)
+	}
+	if config.Enrichment.IP2ASNFile != "" {
+		config.Enrichment.IP2ASNFile = resolvePath(filename, config.Enrichment.IP2ASNFile)
+	}
+
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
 	}
@@ -89,6 +319,66 @@ func LoadConfig(filename string) (*Config, error) {
 	return &config, nil
 }
 
+// validate sets the backoff defaults and checks the settings
+func (b *BackoffConfig) validate() error {
+	if b.Initial == 0 {
+		b.Initial = DefaultBackoffInitial
+	}
+	if b.Max == 0 {
+		b.Max = DefaultBackoffMax
+	}
+	if b.Initial < 0 || b.Max < 0 {
+		return fmt.Errorf("delays must not be negative")
+	}
+	if b.Max < b.Initial {
+		return fmt.Errorf("max must not be less than initial")
+	}
+	return nil
+}
+
+// validate sets the circuit breaker defaults and checks the settings
+func (b *CircuitBreakerConfig) validate() error {
+	if b.Threshold == 0 {
+		b.Threshold = DefaultCircuitBreakerThreshold
+	}
+	if b.Cooldown == 0 {
+		b.Cooldown = DefaultCircuitBreakerCooldown
+	}
+	if len(b.Reasons) == 0 {
+		b.Reasons = append([]string(nil), DefaultCircuitBreakerReasons...)
+	}
+	if b.Threshold < 0 {
+		return fmt.Errorf("threshold must be at least 1")
+	}
+	if b.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	for _, reason := range b.Reasons {
+		if !knownReason(reason) {
+			return fmt.Errorf("unknown reason %q (must be one of %s)", reason, strings.Join(ErrorReasons, ", "))
+		}
+	}
+	return nil
+}
+
+// knownReason reports whether reason is one of ErrorReasons
+func knownReason(reason string) bool {
+	for _, r := range ErrorReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath makes a path from the config file relative to its directory
+func resolvePath(configFile, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configFile), path)
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	// Set default server config if not specified
@@ -99,8 +389,44 @@ func (c *Config) Validate() error {
 		c.Server.MetricsPath = "/metrics"
 	}
 
-	if len(c.Targets) == 0 {
-		return fmt.Errorf("no targets defined in configuration")
+	if c.Scheduler.MaxConcurrentTraces == 0 {
+		c.Scheduler.MaxConcurrentTraces = DefaultMaxConcurrentTraces
+	}
+	if c.Scheduler.MaxConcurrentTraces < 0 {
+		return fmt.Errorf("scheduler: max_concurrent_traces must be at least 1")
+	}
+	if c.Scheduler.Splay < 0 {
+		return fmt.Errorf("scheduler: splay must not be negative")
+	}
+	if c.Scheduler.Jitter < 0 || c.Scheduler.Jitter > 1 {
+		return fmt.Errorf("scheduler: jitter must be between 0 and 1")
+	}
+	if err := c.Scheduler.Backoff.validate(); err != nil {
+		return fmt.Errorf("scheduler: backoff: %w", err)
+	}
+	if err := c.Scheduler.CircuitBreaker.validate(); err != nil {
+		return fmt.Errorf("scheduler: circuit_breaker: %w", err)
+	}
+
+	// Target groups are expanded into plain targets
+	for i, group := range c.TargetGroups {
+		if len(group.Targets) == 0 {
+			return fmt.Errorf("target group %d: no targets defined", i)
+		}
+		for _, target := range group.Targets {
+			c.Targets = append(c.Targets, group.apply(target))
+		}
+	}
+	c.TargetGroups = nil
+
+	if len(c.Targets) == 0 && len(c.TargetFiles) == 0 && len(c.Modules) == 0 {
+		return fmt.Errorf("no targets, target files or modules defined in configuration")
+	}
+
+	for _, pattern := range c.TargetFiles {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("target_files: invalid pattern %q: %w", pattern, err)
+		}
 	}
 
 	targetNames := make(map[string]bool)
@@ -109,12 +435,10 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("target %d: host is required", i)
 		}
 
-		if target.Interval < time.Second {
-			return fmt.Errorf("target %s: interval must be at least 1 second", target.Host)
-		}
-
-		if target.MaxHops < 1 || target.MaxHops > 64 {
-			return fmt.Errorf("target %s: max_hops must be between 1 and 64", target.Host)
+		c.Targets[i].setDefaults()
+		target = c.Targets[i]
+		if err := c.Targets[i].validate(); err != nil {
+			return fmt.Errorf("target %s: %w", target.Host, err)
 		}
 
 		// Check for duplicate names
@@ -124,5 +448,172 @@ func (c *Config) Validate() error {
 		targetNames[target.Name] = true
 	}
 
+	for name, module := range c.Modules {
+		if module.MaxHops == 0 {
+			module.MaxHops = DefaultModule.MaxHops
+		}
+		module.Backend = strings.ToLower(module.Backend)
+		if module.Backend == "" {
+			module.Backend = DefaultModule.Backend
+		}
+		module.Protocol = strings.ToLower(module.Protocol)
+		if module.Protocol == "" {
+			module.Protocol = defaultProtocol(module.Backend)
+		}
+
+		if err := validateProbe(module.MaxHops, module.Protocol, module.Port, module.Queries, module.Backend, module.Binary); err != nil {
+			return fmt.Errorf("module %s: %w", name, err)
+		}
+		c.Modules[name] = module
+	}
+
+	return nil
+}
+
+// validate checks a single target and fills in the protocol default
+func (t *Target) validate() error {
+	if err := ValidateHost(t.Host); err != nil {
+		return err
+	}
+
+	if t.Interval < time.Second {
+		return fmt.Errorf("interval must be at least 1 second")
+	}
+
+	if err := validateLabels(t.Labels); err != nil {
+		return err
+	}
+
+	if t.Backend == "" {
+		t.Backend = BackendNextTrace
+	}
+	if t.Protocol == "" {
+		t.Protocol = defaultProtocol(t.Backend)
+	}
+
+	return validateProbe(t.MaxHops, t.Protocol, t.Port, t.Queries, t.Backend, t.Binary)
+}
+
+// apply fills in the options a target leaves unset from the group and merges
+// the group labels under the target's own
+func (g TargetGroup) apply(target Target) Target {
+	if target.Interval == 0 {
+		target.Interval = g.Interval
+	}
+	if target.MaxHops == 0 {
+		target.MaxHops = g.MaxHops
+	}
+	// The group port only makes sense together with the group protocol
+	if target.Protocol == "" {
+		target.Protocol = strings.ToLower(g.Protocol)
+		if target.Port == 0 {
+			target.Port = g.Port
+		}
+	}
+	if target.Queries == 0 {
+		target.Queries = g.Queries
+	}
+	if target.Backend == "" {
+		target.Backend = strings.ToLower(g.Backend)
+		if target.Binary == "" {
+			target.Binary = g.Binary
+		}
+	}
+
+	if len(g.Labels) > 0 {
+		labels := copyLabels(g.Labels)
+		for name, value := range target.Labels {
+			labels[name] = value
+		}
+		target.Labels = labels
+	}
+
+	return target
+}
+
+// validateLabels checks that custom labels are valid Prometheus label names
+// and don't clash with the exporter's own labels
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNameRE.MatchString(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if strings.HasPrefix(name, "__") {
+			return fmt.Errorf("label name %q is reserved for internal use", name)
+		}
+		for _, builtin := range BuiltinLabels {
+			if name == builtin {
+				return fmt.Errorf("label name %q clashes with a built-in label", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Module returns the probe module with the given name. An empty name selects
+// the module named "default", falling back to DefaultModule.
+func (c *Config) Module(name string) (Module, bool) {
+	if name == "" {
+		if module, exists := c.Modules[DefaultModuleName]; exists {
+			return module, true
+		}
+		return DefaultModule, true
+	}
+
+	module, exists := c.Modules[name]
+	return module, exists
+}
+
+// ValidateHost checks that a host can safely be passed to nexttrace as a
+// positional argument
+func ValidateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if strings.HasPrefix(host, "-") {
+		return fmt.Errorf("invalid host %q: must not start with '-'", host)
+	}
+	if strings.IndexFunc(host, func(r rune) bool { return r <= ' ' || r == 0x7f }) != -1 {
+		return fmt.Errorf("invalid host %q: must not contain whitespace or control characters", host)
+	}
+	return nil
+}
+
+// validateProbe checks the probe settings shared by targets and modules
+func validateProbe(maxHops int, protocol string, port int, queries int, backend string, binary string) error {
+	if maxHops < 1 || maxHops > 64 {
+		return fmt.Errorf("max_hops must be between 1 and 64")
+	}
+
+	if queries < 0 || queries > MaxQueries {
+		return fmt.Errorf("queries must be between 0 and %d (0 uses the default)", MaxQueries)
+	}
+
+	switch protocol {
+	case ProtocolICMP:
+		if port != 0 {
+			return fmt.Errorf("port is only supported with tcp or udp protocol")
+		}
+	case ProtocolTCP, ProtocolUDP:
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("port must be between 0 and 65535 (0 uses the default)")
+		}
+	default:
+		return fmt.Errorf("unsupported protocol %q (must be icmp, tcp or udp)", protocol)
+	}
+
+	switch backend {
+	case BackendNextTrace, BackendMTR, BackendTraceroute:
+	case BackendNative:
+		if protocol == ProtocolTCP {
+			return fmt.Errorf("the native backend only supports icmp and udp protocols")
+		}
+		if binary != "" {
+			return fmt.Errorf("binary is not supported by the native backend")
+		}
+	default:
+		return fmt.Errorf("unsupported backend %q (must be nexttrace, mtr, traceroute or native)", backend)
+	}
+
 	return nil
 }
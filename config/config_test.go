@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -142,6 +145,262 @@ func TestConfigValidation(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "tcp with port",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Protocol: ProtocolTCP,
+						Port:     443,
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "negative queries",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Queries:  -1,
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "too many queries",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Queries:  50,
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "unsupported protocol",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Protocol: "sctp",
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "port with icmp",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Protocol: ProtocolICMP,
+						Port:     443,
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "tcp without port",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Protocol: ProtocolTCP,
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "invalid port",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Protocol: ProtocolUDP,
+						Port:     70000,
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "custom labels",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Labels:   map[string]string{"region": "eu", "team": "netops"},
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "label clashes with built-in label",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Labels:   map[string]string{"hop_ip": "10.0.0.1"},
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "label clashes with circuit breaker state label",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Labels:   map[string]string{"state": "prod"},
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "invalid label name",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Labels:   map[string]string{"data-center": "fra1"},
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "native backend",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Protocol: ProtocolUDP,
+						Backend:  BackendNative,
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "native backend with tcp",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Protocol: ProtocolTCP,
+						Backend:  BackendNative,
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "native backend with binary",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Backend:  BackendNative,
+						Binary:   "/usr/bin/traceroute",
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "mtr backend with binary",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Backend:  BackendMTR,
+						Binary:   "/usr/sbin/mtr",
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "unsupported backend",
+			config: Config{
+				Targets: []Target{
+					{
+						Host:     "8.8.8.8",
+						Name:     "test",
+						Interval: 5 * time.Minute,
+						MaxHops:  30,
+						Backend:  "scamper",
+					},
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "empty target group",
+			config: Config{
+				TargetGroups: []TargetGroup{
+					{Labels: map[string]string{"region": "eu"}},
+				},
+			},
+			expectErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -192,4 +451,361 @@ targets:
 	if target.MaxHops != 30 {
 		t.Errorf("Expected default max_hops 30, got %d", target.MaxHops)
 	}
+	if target.Protocol != ProtocolICMP {
+		t.Errorf("Expected default protocol icmp, got %s", target.Protocol)
+	}
+}
+
+func TestTargetProtocol(t *testing.T) {
+	content := `
+targets:
+  - host: example.com
+    name: example_https
+    protocol: TCP
+    port: 443
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	target := cfg.Targets[0]
+	if target.Protocol != ProtocolTCP {
+		t.Errorf("Expected protocol tcp, got %s", target.Protocol)
+	}
+	if target.Port != 443 {
+		t.Errorf("Expected port 443, got %d", target.Port)
+	}
+}
+
+func TestTracerouteDefaultProtocol(t *testing.T) {
+	content := `
+modules:
+  traceroute:
+    backend: traceroute
+targets:
+  - host: 8.8.8.8
+    backend: traceroute
+  - host: 1.1.1.1
+    backend: traceroute
+    protocol: icmp
+  - host: 9.9.9.9
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	// traceroute's ICMP mode needs root, so it defaults to UDP probes
+	expected := []string{ProtocolUDP, ProtocolICMP, ProtocolICMP}
+	for i, protocol := range expected {
+		if cfg.Targets[i].Protocol != protocol {
+			t.Errorf("Expected target %s protocol %s, got %s", cfg.Targets[i].Host, protocol, cfg.Targets[i].Protocol)
+		}
+	}
+
+	module, _ := cfg.Module("traceroute")
+	if module.Protocol != ProtocolUDP {
+		t.Errorf("Expected traceroute module protocol udp, got %s", module.Protocol)
+	}
+}
+
+func TestTargetGroups(t *testing.T) {
+	content := `
+target_groups:
+  - interval: 10m
+    protocol: tcp
+    port: 443
+    labels:
+      region: eu
+      tier: edge
+    targets:
+      - host: example.com
+      - host: example.org
+        interval: 1m
+        protocol: icmp
+        labels:
+          tier: core
+targets:
+  - host: 8.8.8.8
+    labels:
+      region: us
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if len(cfg.Targets) != 3 {
+		t.Fatalf("Expected 3 targets, got %d", len(cfg.Targets))
+	}
+
+	plain := cfg.Targets[0]
+	if plain.Labels["region"] != "us" {
+		t.Errorf("Expected region us, got %q", plain.Labels["region"])
+	}
+
+	inherited := cfg.Targets[1]
+	if inherited.Interval != 10*time.Minute {
+		t.Errorf("Expected group interval 10m, got %v", inherited.Interval)
+	}
+	if inherited.Protocol != ProtocolTCP || inherited.Port != 443 {
+		t.Errorf("Expected group protocol tcp/443, got %s/%d", inherited.Protocol, inherited.Port)
+	}
+	if inherited.Labels["region"] != "eu" || inherited.Labels["tier"] != "edge" {
+		t.Errorf("Expected group labels, got %v", inherited.Labels)
+	}
+
+	overridden := cfg.Targets[2]
+	if overridden.Interval != time.Minute {
+		t.Errorf("Expected target interval 1m, got %v", overridden.Interval)
+	}
+	if overridden.Protocol != ProtocolICMP || overridden.Port != 0 {
+		t.Errorf("Expected target protocol icmp without port, got %s/%d", overridden.Protocol, overridden.Port)
+	}
+	if overridden.Labels["region"] != "eu" || overridden.Labels["tier"] != "core" {
+		t.Errorf("Expected merged labels, got %v", overridden.Labels)
+	}
+}
+
+func TestModules(t *testing.T) {
+	content := `
+modules:
+  tcp_443:
+    protocol: tcp
+    port: 443
+    max_hops: 20
+  icmp_fast: {}
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A config with only modules is valid for /probe-driven setups
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	module, exists := cfg.Module("tcp_443")
+	if !exists {
+		t.Fatal("Expected module tcp_443 to exist")
+	}
+	target := module.Target("example.com")
+	if target.Host != "example.com" || target.Name != "example.com" {
+		t.Errorf("Expected target for example.com, got %+v", target)
+	}
+	if target.Protocol != ProtocolTCP || target.Port != 443 || target.MaxHops != 20 {
+		t.Errorf("Unexpected module target settings: %+v", target)
+	}
+
+	// Defaults are applied to modules
+	module, _ = cfg.Module("icmp_fast")
+	if module.Protocol != ProtocolICMP || module.MaxHops != 30 {
+		t.Errorf("Expected module defaults, got %+v", module)
+	}
+
+	// No module requested and no "default" module configured
+	module, exists = cfg.Module("")
+	if !exists || module != DefaultModule {
+		t.Errorf("Expected built-in default module, got %+v", module)
+	}
+
+	if _, exists := cfg.Module("missing"); exists {
+		t.Error("Expected unknown module to be reported missing")
+	}
+}
+
+func TestValidateHost(t *testing.T) {
+	tests := []struct {
+		host      string
+		expectErr bool
+	}{
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+		{"www.example.com", false},
+		{"", true},
+		{"--help", true},
+		{"example.com --tcp", true},
+		{"example.com\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := ValidateHost(tt.host)
+			if tt.expectErr && err == nil {
+				t.Error("Expected error but got none")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestSchedulerConfig(t *testing.T) {
+	content := `
+scheduler:
+  max_concurrent_traces: 4
+  splay: 30s
+  jitter: 0.1
+  backoff:
+    max: 10m
+  circuit_breaker:
+    threshold: 3
+    reasons: [dns_failure]
+targets:
+  - host: 8.8.8.8
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if cfg.Scheduler.MaxConcurrentTraces != 4 {
+		t.Errorf("Expected max_concurrent_traces 4, got %d", cfg.Scheduler.MaxConcurrentTraces)
+	}
+	if cfg.Scheduler.Splay != 30*time.Second {
+		t.Errorf("Expected splay 30s, got %v", cfg.Scheduler.Splay)
+	}
+	if cfg.Scheduler.Jitter != 0.1 {
+		t.Errorf("Expected jitter 0.1, got %v", cfg.Scheduler.Jitter)
+	}
+	if backoff := cfg.Scheduler.Backoff; backoff.Initial != DefaultBackoffInitial || backoff.Max != 10*time.Minute {
+		t.Errorf("Expected backoff from 30s to 10m, got %+v", backoff)
+	}
+	breaker := cfg.Scheduler.CircuitBreaker
+	if breaker.Threshold != 3 || breaker.Cooldown != DefaultCircuitBreakerCooldown || len(breaker.Reasons) != 1 || breaker.Reasons[0] != "dns_failure" {
+		t.Errorf("Unexpected circuit breaker config: %+v", breaker)
+	}
+
+	// Defaults
+	defaults := Config{Targets: []Target{{Host: "8.8.8.8", Name: "test", Interval: time.Minute, MaxHops: 30}}}
+	if err := defaults.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if defaults.Scheduler.MaxConcurrentTraces != DefaultMaxConcurrentTraces {
+		t.Errorf("Expected default max_concurrent_traces %d, got %d", DefaultMaxConcurrentTraces, defaults.Scheduler.MaxConcurrentTraces)
+	}
+
+	invalid := Config{
+		Scheduler: SchedulerConfig{Jitter: 2},
+		Targets:   []Target{{Host: "8.8.8.8", Name: "test", Interval: time.Minute, MaxHops: 30}},
+	}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected error for jitter above 1")
+	}
+
+	invalid = Config{
+		Scheduler: SchedulerConfig{Backoff: BackoffConfig{Initial: time.Hour, Max: time.Minute}},
+		Targets:   []Target{{Host: "8.8.8.8", Name: "test", Interval: time.Minute, MaxHops: 30}},
+	}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected error for backoff max below initial")
+	}
+
+	// A misspelled reason would never open the breaker
+	invalid = Config{
+		Scheduler: SchedulerConfig{CircuitBreaker: CircuitBreakerConfig{Reasons: []string{"dns_failures"}}},
+		Targets:   []Target{{Host: "8.8.8.8", Name: "test", Interval: time.Minute, MaxHops: 30}},
+	}
+	if err := invalid.Validate(); err == nil || !strings.Contains(err.Error(), "dns_failures") {
+		t.Errorf("Expected error for unknown circuit breaker reason, got %v", err)
+	}
+}
+
+func TestEnrichmentConfig(t *testing.T) {
+	content := `
+enrichment:
+  mmdb_files:
+    - GeoLite2-ASN.mmdb
+    - /usr/share/GeoIP/GeoLite2-City.mmdb
+  ip2asn_file: data/ip2asn-combined.tsv.gz
+  override: true
+targets:
+  - host: 8.8.8.8
+`
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	// Relative paths are resolved against the config file directory
+	expected := []string{filepath.Join(dir, "GeoLite2-ASN.mmdb"), "/usr/share/GeoIP/GeoLite2-City.mmdb"}
+	if !reflect.DeepEqual(cfg.Enrichment.MMDBFiles, expected) {
+		t.Errorf("Expected mmdb_files %v, got %v", expected, cfg.Enrichment.MMDBFiles)
+	}
+	if cfg.Enrichment.IP2ASNFile != filepath.Join(dir, "data/ip2asn-combined.tsv.gz") {
+		t.Errorf("Expected ip2asn_file in config directory, got %s", cfg.Enrichment.IP2ASNFile)
+	}
+	if !cfg.Enrichment.Override {
+		t.Error("Expected override to be true")
+	}
 }
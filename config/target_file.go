@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Reserved labels in target files. They set target options instead of being
+// attached to the target's metrics.
+const (
+	LabelTargetName = "__target_name__"
+	LabelInterval   = "__interval__"
+	LabelMaxHops    = "__max_hops__"
+	LabelProtocol   = "__protocol__"
+	LabelPort       = "__port__"
+	LabelQueries    = "__queries__"
+	LabelBackend    = "__backend__"
+)
+
+// targetGroup is one entry of a target file, in the format of Prometheus
+// file_sd_configs
+type targetGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// LoadTargetFile reads a YAML or JSON target file in the Prometheus
+// file_sd_configs format. Every entry of `targets` is a host; `labels` are
+// attached to each of them, except labels starting with "__" which are
+// either one of the reserved option labels or dropped.
+func LoadTargetFile(filename string) ([]Target, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target file: %w", err)
+	}
+
+	// JSON is valid YAML, so one decoder handles both formats
+	var groups []targetGroup
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse target file: %w", err)
+	}
+
+	var targets []Target
+	names := make(map[string]bool)
+	for i, group := range groups {
+		template, err := targetFromLabels(group.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %w", i, err)
+		}
+		if template.Name != "" && len(group.Targets) > 1 {
+			return nil, fmt.Errorf("group %d: %s can only be set for groups with a single target", i, LabelTargetName)
+		}
+
+		for _, host := range group.Targets {
+			target := template
+			target.Host = host
+			target.Labels = copyLabels(template.Labels)
+			target.setDefaults()
+
+			if err := target.validate(); err != nil {
+				return nil, fmt.Errorf("group %d: target %s: %w", i, host, err)
+			}
+
+			if names[target.Name] {
+				return nil, fmt.Errorf("duplicate target name: %s", target.Name)
+			}
+			names[target.Name] = true
+
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+// targetFromLabels builds the options shared by a target group from its labels
+func targetFromLabels(labels map[string]string) (Target, error) {
+	var target Target
+
+	for name, value := range labels {
+		var err error
+		switch name {
+		case LabelTargetName:
+			target.Name = value
+		case LabelInterval:
+			target.Interval, err = time.ParseDuration(value)
+		case LabelMaxHops:
+			target.MaxHops, err = strconv.Atoi(value)
+		case LabelProtocol:
+			target.Protocol = strings.ToLower(value)
+		case LabelPort:
+			target.Port, err = strconv.Atoi(value)
+		case LabelQueries:
+			target.Queries, err = strconv.Atoi(value)
+		case LabelBackend:
+			target.Backend = strings.ToLower(value)
+		default:
+			if strings.HasPrefix(name, "__") {
+				continue
+			}
+			if target.Labels == nil {
+				target.Labels = make(map[string]string)
+			}
+			target.Labels[name] = value
+		}
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid value %q for label %s: %w", value, name, err)
+		}
+	}
+
+	return target, nil
+}
+
+// copyLabels returns a copy of a label set, or nil for an empty one
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for name, value := range labels {
+		out[name] = value
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadTargetFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "yaml",
+			content: `
+- targets: ['8.8.8.8', '1.1.1.1']
+  labels:
+    region: eu-west
+    __interval__: 1m
+- targets: ['example.com']
+  labels:
+    __target_name__: example_https
+    __protocol__: tcp
+    __port__: "443"
+    __meta_ignored: "x"
+`,
+		},
+		{
+			name: "json",
+			content: `[
+  {"targets": ["8.8.8.8", "1.1.1.1"], "labels": {"region": "eu-west", "__interval__": "1m"}},
+  {"targets": ["example.com"], "labels": {"__target_name__": "example_https", "__protocol__": "tcp", "__port__": "443", "__meta_ignored": "x"}}
+]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "targets."+tt.name)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			targets, err := LoadTargetFile(path)
+			if err != nil {
+				t.Fatalf("LoadTargetFile failed: %v", err)
+			}
+
+			if len(targets) != 3 {
+				t.Fatalf("Expected 3 targets, got %d", len(targets))
+			}
+
+			google := targets[0]
+			if google.Name != "8.8.8.8" || google.Interval != time.Minute || google.MaxHops != 30 || google.Protocol != ProtocolICMP {
+				t.Errorf("Unexpected target: %+v", google)
+			}
+			if google.Labels["region"] != "eu-west" || len(google.Labels) != 1 {
+				t.Errorf("Expected only the region label, got %v", google.Labels)
+			}
+
+			example := targets[2]
+			if example.Name != "example_https" || example.Protocol != ProtocolTCP || example.Port != 443 {
+				t.Errorf("Unexpected target: %+v", example)
+			}
+			if example.Interval != 5*time.Minute {
+				t.Errorf("Expected default interval 5m, got %v", example.Interval)
+			}
+			if len(example.Labels) != 0 {
+				t.Errorf("Expected reserved labels to be dropped, got %v", example.Labels)
+			}
+		})
+	}
+}
+
+func TestLoadTargetFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"invalid yaml", "- targets: [unclosed"},
+		{"invalid option", "- targets: ['8.8.8.8']\n  labels:\n    __max_hops__: lots\n"},
+		{"invalid target", "- targets: ['8.8.8.8']\n  labels:\n    __protocol__: sctp\n"},
+		{"name for several targets", "- targets: ['8.8.8.8', '1.1.1.1']\n  labels:\n    __target_name__: dns\n"},
+		{"duplicate target", "- targets: ['8.8.8.8']\n- targets: ['8.8.8.8']\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "targets.yml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := LoadTargetFile(path); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
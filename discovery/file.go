@@ -0,0 +1,208 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+const (
+	// refreshInterval re-reads all target files in case a change was missed
+	refreshInterval = 5 * time.Minute
+	// debounceDelay groups the burst of events a single file write produces
+	debounceDelay = 500 * time.Millisecond
+)
+
+// FileDiscovery discovers targets from file_sd style target files matching
+// a set of glob patterns and watches them for changes
+type FileDiscovery struct {
+	patterns []string
+	logger   *slog.Logger
+	files    map[string][]config.Target // Last successfully loaded targets per file
+	mu       sync.Mutex
+}
+
+// NewFileDiscovery creates a new FileDiscovery for the given glob patterns
+func NewFileDiscovery(patterns []string, logger *slog.Logger) *FileDiscovery {
+	cleaned := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		cleaned[i] = filepath.Clean(pattern)
+	}
+
+	return &FileDiscovery{
+		patterns: cleaned,
+		logger:   logger,
+		files:    make(map[string][]config.Target),
+	}
+}
+
+// Refresh re-reads every file matching the patterns and returns the merged
+// targets. A file that fails to load keeps the targets it last loaded with.
+func (d *FileDiscovery) Refresh() []config.Target {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	matched := make(map[string]bool)
+	for _, pattern := range d.patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			d.logger.Error("Invalid target file pattern", "pattern", pattern, "error", err)
+			continue
+		}
+		for _, path := range paths {
+			matched[path] = true
+		}
+	}
+
+	for path := range d.files {
+		if !matched[path] {
+			d.logger.Info("Target file removed", "file", path)
+			delete(d.files, path)
+		}
+	}
+
+	for path := range matched {
+		targets, err := config.LoadTargetFile(path)
+		if err != nil {
+			d.logger.Error("Failed to load target file, keeping previous targets",
+				"file", path,
+				"error", err)
+			continue
+		}
+		d.files[path] = targets
+	}
+
+	return d.merge()
+}
+
+// merge combines the targets of all files in path order. When several files
+// define the same target name the first one wins.
+// Must be called with mu held.
+func (d *FileDiscovery) merge() []config.Target {
+	paths := make([]string, 0, len(d.files))
+	for path := range d.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var targets []config.Target
+	seen := make(map[string]string)
+	for _, path := range paths {
+		for _, target := range d.files[path] {
+			if first, exists := seen[target.Name]; exists {
+				d.logger.Warn("Ignoring duplicate target from target file",
+					"target", target.Name,
+					"file", path,
+					"first_file", first)
+				continue
+			}
+			seen[target.Name] = path
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// Run watches the directories of the patterns and calls onChange with the
+// merged targets whenever they change, until ctx is done. Directories that
+// cannot be watched are still picked up by the periodic refresh.
+func (d *FileDiscovery) Run(ctx context.Context, initial []config.Target, onChange func([]config.Target)) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		d.logger.Error("Failed to create target file watcher, relying on periodic refresh", "error", err)
+	} else {
+		defer watcher.Close()
+		for _, dir := range d.watchDirs() {
+			if err := watcher.Add(dir); err != nil {
+				d.logger.Warn("Failed to watch target file directory", "dir", dir, "error", err)
+			}
+		}
+	}
+
+	var events chan fsnotify.Event
+	var errs chan error
+	if watcher != nil {
+		events = watcher.Events
+		errs = watcher.Errors
+	}
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	debounce := time.NewTimer(debounceDelay)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	last := initial
+	refresh := func() {
+		targets := d.Refresh()
+		if reflect.DeepEqual(targets, last) {
+			return
+		}
+		last = targets
+		onChange(targets)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if d.matches(event.Name) {
+				debounce.Reset(debounceDelay)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			d.logger.Error("Target file watcher error", "error", err)
+		case <-debounce.C:
+			refresh()
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
+
+// watchDirs returns the directories to watch for the patterns. Directories
+// that contain glob characters themselves cannot be watched.
+func (d *FileDiscovery) watchDirs() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, pattern := range d.patterns {
+		dir := filepath.Dir(pattern)
+		if strings.ContainsAny(dir, "*?[") {
+			d.logger.Warn("Cannot watch target file directory with glob characters, relying on periodic refresh",
+				"pattern", pattern)
+			continue
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// matches reports whether path matches any of the patterns
+func (d *FileDiscovery) matches(path string) bool {
+	for _, pattern := range d.patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
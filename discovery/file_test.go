@@ -0,0 +1,81 @@
+package discovery
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileDiscoveryRefresh(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	writeFile(t, filepath.Join(dir, "a.yml"), "- targets: ['8.8.8.8', '1.1.1.1']\n")
+	writeFile(t, filepath.Join(dir, "b.yml"), "- targets: ['1.1.1.1', '9.9.9.9']\n")
+	writeFile(t, filepath.Join(dir, "ignored.txt"), "- targets: ['4.4.4.4']\n")
+
+	d := NewFileDiscovery([]string{filepath.Join(dir, "*.yml")}, logger)
+
+	targets := d.Refresh()
+	if len(targets) != 3 {
+		t.Fatalf("Expected 3 targets with the duplicate dropped, got %d", len(targets))
+	}
+
+	// A broken file keeps its previous targets
+	writeFile(t, filepath.Join(dir, "b.yml"), "- targets: [broken")
+	if targets := d.Refresh(); len(targets) != 3 {
+		t.Errorf("Expected broken file to keep its targets, got %d targets", len(targets))
+	}
+
+	// A removed file drops its targets
+	if err := os.Remove(filepath.Join(dir, "b.yml")); err != nil {
+		t.Fatal(err)
+	}
+	if targets := d.Refresh(); len(targets) != 2 {
+		t.Errorf("Expected 2 targets after removing a file, got %d", len(targets))
+	}
+}
+
+func TestFileDiscoveryWatch(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	writeFile(t, filepath.Join(dir, "a.yml"), "- targets: ['8.8.8.8']\n")
+
+	d := NewFileDiscovery([]string{filepath.Join(dir, "*.yml")}, logger)
+	initial := d.Refresh()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan []config.Target, 1)
+	go d.Run(ctx, initial, func(targets []config.Target) {
+		changes <- targets
+	})
+
+	// Give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+	writeFile(t, filepath.Join(dir, "b.yml"), "- targets: ['1.1.1.1']\n")
+
+	select {
+	case targets := <-changes:
+		if len(targets) != 2 {
+			t.Errorf("Expected 2 targets after adding a file, got %d", len(targets))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for target file change")
+	}
+}
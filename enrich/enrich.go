@@ -0,0 +1,224 @@
+package enrich
+
+import (
+	"log/slog"
+	"net/netip"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// Info is what a local database knows about an address
+type Info struct {
+	ASN         string
+	ASName      string
+	CountryCode string
+	Location    string
+	Latitude    float64
+	Longitude   float64
+}
+
+// hasCoordinates reports whether the info has a latitude and longitude
+func (i Info) hasCoordinates() bool {
+	return i.Latitude != 0 || i.Longitude != 0
+}
+
+// merge fills the fields of i that are empty from other
+func (i *Info) merge(other Info) {
+	if i.ASN == "" {
+		i.ASN = other.ASN
+		i.ASName = other.ASName
+	}
+	if i.CountryCode == "" {
+		i.CountryCode = other.CountryCode
+	}
+	if i.Location == "" {
+		i.Location = other.Location
+	}
+	if !i.hasCoordinates() {
+		i.Latitude = other.Latitude
+		i.Longitude = other.Longitude
+	}
+}
+
+// source is a loaded database
+type source interface {
+	lookup(addr netip.Addr) (Info, bool)
+}
+
+// database is a database file that is loaded again whenever it changes
+type database struct {
+	path    string
+	load    func(path string) (source, error)
+	modTime time.Time
+	size    int64
+	missing bool   // Whether the file was missing on the last check
+	source  source // nil until loaded successfully
+}
+
+// refresh loads the file if it changed since it was last loaded. On failure
+// the previously loaded data is kept.
+func (d *database) refresh(logger *slog.Logger) {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		// Only log once until the file is back
+		if !d.missing {
+			logger.Error("Failed to read enrichment database", "path", d.path, "error", err)
+		}
+		d.missing = true
+		d.modTime, d.size = time.Time{}, 0
+		return
+	}
+	d.missing = false
+	if info.ModTime().Equal(d.modTime) && info.Size() == d.size {
+		return
+	}
+	d.modTime, d.size = info.ModTime(), info.Size()
+
+	src, err := d.load(d.path)
+	if err != nil {
+		logger.Error("Failed to load enrichment database", "path", d.path, "error", err)
+		return
+	}
+	d.source = src
+	logger.Info("Loaded enrichment database", "path", d.path)
+}
+
+// Enricher fills in ASN and geo data of trace results from local databases,
+// for hosts where the nexttrace geo API is unreachable or for backends that
+// report no such data at all
+type Enricher struct {
+	mutex     sync.Mutex
+	cfg       config.EnrichmentConfig
+	databases []*database
+	logger    *slog.Logger
+}
+
+// New creates an Enricher without databases, which leaves results untouched
+// until it is configured
+func New(logger *slog.Logger) *Enricher {
+	return &Enricher{logger: logger}
+}
+
+// Configure sets the databases to use. Databases are loaded right away and
+// again whenever their files change; unchanged settings keep the loaded data.
+func (e *Enricher) Configure(cfg config.EnrichmentConfig) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if reflect.DeepEqual(cfg, e.cfg) {
+		return
+	}
+	e.cfg = cfg
+
+	// MaxMind databases come first, their geo data is more precise than the
+	// registration country of ip2asn
+	e.databases = nil
+	for _, path := range cfg.MMDBFiles {
+		e.databases = append(e.databases, &database{path: path, load: loadMMDB})
+	}
+	if cfg.IP2ASNFile != "" {
+		e.databases = append(e.databases, &database{path: cfg.IP2ASNFile, load: loadIP2ASN})
+	}
+
+	for _, db := range e.databases {
+		db.refresh(e.logger)
+	}
+}
+
+// Refresh reloads the databases whose files changed since they were loaded
+func (e *Enricher) Refresh() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, db := range e.databases {
+		db.refresh(e.logger)
+	}
+}
+
+// Apply adds local data to the hops and responders of a result from the
+// databases as currently loaded, without checking their files for changes
+func (e *Enricher) Apply(result *parser.NextTraceResult) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.databases) == 0 || result == nil {
+		return
+	}
+
+	cache := make(map[string]Info)
+	lookup := func(ip string) (Info, bool) {
+		if info, exists := cache[ip]; exists {
+			return info, true
+		}
+		info, found := e.lookup(ip)
+		if found {
+			cache[ip] = info
+		}
+		return info, found
+	}
+
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if info, found := lookup(hop.IP); found {
+			apply(info, e.cfg.Override, &hop.ASN, &hop.ASName, &hop.CountryCode, &hop.Location, &hop.Latitude, &hop.Longitude)
+		}
+		for j := range hop.Responders {
+			r := &hop.Responders[j]
+			if info, found := lookup(r.IP); found {
+				apply(info, e.cfg.Override, &r.ASN, &r.ASName, &r.CountryCode, &r.Location, &r.Latitude, &r.Longitude)
+			}
+		}
+	}
+}
+
+// lookup queries all databases for an address and merges their answers
+func (e *Enricher) lookup(ip string) (Info, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Info{}, false
+	}
+	addr = addr.Unmap()
+
+	var (
+		info  Info
+		found bool
+	)
+	for _, db := range e.databases {
+		if db.source == nil {
+			continue
+		}
+		if dbInfo, ok := db.source.lookup(addr); ok {
+			info.merge(dbInfo)
+			found = true
+		}
+	}
+	return info, found
+}
+
+// apply writes local data into the fields of a hop or responder. Without
+// override only empty fields are filled, and an AS name is only added when
+// the ASN agrees with the local data.
+func apply(info Info, override bool, asn, asName, countryCode, location *string, latitude, longitude *float64) {
+	if info.ASN != "" && (override || *asn == "") {
+		*asn = info.ASN
+		*asName = info.ASName
+	} else if info.ASN == *asn && *asName == "" {
+		*asName = info.ASName
+	}
+
+	if info.CountryCode != "" && (override || *countryCode == "") {
+		*countryCode = info.CountryCode
+	}
+	if info.Location != "" && (override || *location == "") {
+		*location = info.Location
+	}
+	if info.hasCoordinates() && (override || (*latitude == 0 && *longitude == 0)) {
+		*latitude = info.Latitude
+		*longitude = info.Longitude
+	}
+}
@@ -0,0 +1,161 @@
+package enrich
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// testResult returns a result with one hop as reported by nexttrace and one
+// hop without any data
+func testResult() *parser.NextTraceResult {
+	return &parser.NextTraceResult{
+		Hops: []parser.Hop{
+			{
+				TTL: 1, IP: "8.8.8.8", ASN: "15169", Location: "Mountain View, United States",
+				Responders: []parser.Responder{{IP: "8.8.8.8", ASN: "15169", Location: "Mountain View, United States"}},
+			},
+			{
+				TTL: 2, IP: "1.0.0.1",
+				Responders: []parser.Responder{{IP: "1.0.0.1"}},
+			},
+			{TTL: 3},
+		},
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	mmdb := writeMMDB(t, map[string]map[string]any{
+		"8.8.8.0/24": {
+			"country":  map[string]any{"iso_code": "US", "names": map[string]any{"en": "United States"}},
+			"location": map[string]any{"latitude": 37.751, "longitude": -97.822},
+		},
+	})
+	ip2asn := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	if err := os.WriteFile(ip2asn, []byte(testIP2ASN), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		override bool
+		hop1     Info
+		hop2     Info
+	}{
+		{
+			name: "fill gaps",
+			// nexttrace's location is kept, the AS name is added since the ASN agrees
+			hop1: Info{ASN: "15169", ASName: "GOOGLE", CountryCode: "US", Location: "Mountain View, United States", Latitude: 37.751, Longitude: -97.822},
+			hop2: Info{ASN: "13335", ASName: "CLOUDFLARENET", CountryCode: "US"},
+		},
+		{
+			name:     "override",
+			override: true,
+			hop1:     Info{ASN: "15169", ASName: "GOOGLE", CountryCode: "US", Location: "United States", Latitude: 37.751, Longitude: -97.822},
+			hop2:     Info{ASN: "13335", ASName: "CLOUDFLARENET", CountryCode: "US"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			e.Configure(config.EnrichmentConfig{
+				MMDBFiles:  []string{mmdb},
+				IP2ASNFile: ip2asn,
+				Override:   tt.override,
+			})
+
+			result := testResult()
+			e.Refresh()
+			e.Apply(result)
+
+			for i, expected := range []Info{tt.hop1, tt.hop2} {
+				hop := result.Hops[i]
+				got := Info{hop.ASN, hop.ASName, hop.CountryCode, hop.Location, hop.Latitude, hop.Longitude}
+				if got != expected {
+					t.Errorf("Hop %d: expected %+v, got %+v", hop.TTL, expected, got)
+				}
+
+				r := hop.Responders[0]
+				got = Info{r.ASN, r.ASName, r.CountryCode, r.Location, r.Latitude, r.Longitude}
+				if got != expected {
+					t.Errorf("Hop %d responder: expected %+v, got %+v", hop.TTL, expected, got)
+				}
+			}
+
+			if hop := result.Hops[2]; hop.ASN != "" || hop.Location != "" {
+				t.Errorf("Expected silent hop to stay empty, got %+v", hop)
+			}
+		})
+	}
+}
+
+func TestEnrichKeepsMismatchedASName(t *testing.T) {
+	ip2asn := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	if err := os.WriteFile(ip2asn, []byte(testIP2ASN), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	e.Configure(config.EnrichmentConfig{IP2ASNFile: ip2asn})
+
+	// The backend reports a different ASN, whose name the database doesn't know
+	result := &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "8.8.8.8", ASN: "64500"}}}
+	e.Refresh()
+	e.Apply(result)
+
+	if hop := result.Hops[0]; hop.ASN != "64500" || hop.ASName != "" {
+		t.Errorf("Expected ASN 64500 without name, got %q/%q", hop.ASN, hop.ASName)
+	}
+}
+
+func TestEnrichReload(t *testing.T) {
+	ip2asn := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	if err := os.WriteFile(ip2asn, []byte("8.8.8.0\t8.8.8.255\t15169\tUS\tGOOGLE\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	e.Configure(config.EnrichmentConfig{IP2ASNFile: ip2asn})
+
+	result := &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "8.8.8.8"}}}
+	e.Refresh()
+	e.Apply(result)
+	if result.Hops[0].ASName != "GOOGLE" {
+		t.Fatalf("Expected AS name GOOGLE, got %q", result.Hops[0].ASName)
+	}
+
+	// A changed file is picked up by the next enrichment
+	if err := os.WriteFile(ip2asn, []byte("8.8.8.0\t8.8.8.255\t15169\tUS\tGOOGLE-RENAMED\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(ip2asn, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	result = &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "8.8.8.8"}}}
+	e.Refresh()
+	e.Apply(result)
+	if result.Hops[0].ASName != "GOOGLE-RENAMED" {
+		t.Errorf("Expected AS name GOOGLE-RENAMED after reload, got %q", result.Hops[0].ASName)
+	}
+
+	// A broken file keeps the previous data
+	if err := os.WriteFile(ip2asn, []byte("garbage\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result = &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "8.8.8.8"}}}
+	e.Refresh()
+	e.Apply(result)
+	if result.Hops[0].ASName != "GOOGLE-RENAMED" {
+		t.Errorf("Expected previous data to be kept, got %q", result.Hops[0].ASName)
+	}
+}
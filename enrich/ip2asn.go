@@ -0,0 +1,111 @@
+package enrich
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ip2asnRange is one line of an ip2asn file: an address range announced by
+// an AS
+type ip2asnRange struct {
+	start, end  netip.Addr
+	asn         string
+	countryCode string
+	name        string
+}
+
+// ip2asnSource is an ip2asn TSV file, see https://iptoasn.com
+type ip2asnSource struct {
+	ranges []ip2asnRange // Sorted by start address
+}
+
+// loadIP2ASN reads an ip2asn TSV file, gzipped if the name ends in .gz. Each
+// line holds range_start, range_end, AS_number, country_code and
+// AS_description, separated by tabs.
+func loadIP2ASN(path string) (source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	src := &ip2asnSource{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected at least 3 fields, got %d", lineNumber, len(fields))
+		}
+		start, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		end, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		// AS 0 marks ranges that are not routed
+		if fields[2] == "0" {
+			continue
+		}
+
+		rng := ip2asnRange{start: start.Unmap(), end: end.Unmap(), asn: fields[2]}
+		if len(fields) > 3 && fields[3] != "None" && fields[3] != "Unknown" {
+			rng.countryCode = fields[3]
+		}
+		if len(fields) > 4 {
+			rng.name = fields[4]
+		}
+		src.ranges = append(src.ranges, rng)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(src.ranges, func(i, j int) bool {
+		return src.ranges[i].start.Less(src.ranges[j].start)
+	})
+	return src, nil
+}
+
+// lookup implements source
+func (s *ip2asnSource) lookup(addr netip.Addr) (Info, bool) {
+	// The last range starting at or before addr is the only candidate
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return addr.Less(s.ranges[i].start)
+	}) - 1
+	if i < 0 || s.ranges[i].end.Less(addr) {
+		return Info{}, false
+	}
+
+	rng := s.ranges[i]
+	return Info{
+		ASN:         rng.asn,
+		ASName:      rng.name,
+		CountryCode: rng.countryCode,
+	}, true
+}
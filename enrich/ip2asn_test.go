@@ -0,0 +1,84 @@
+package enrich
+
+import (
+	"compress/gzip"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testIP2ASN = "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+	"1.0.1.0\t1.0.3.255\t0\tNone\tNot routed\n" +
+	"8.8.8.0\t8.8.8.255\t15169\tUS\tGOOGLE\n" +
+	"2001:4860::\t2001:4860:ffff:ffff:ffff:ffff:ffff:ffff\t15169\tUS\tGOOGLE\n"
+
+func TestIP2ASNLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	if err := os.WriteFile(path, []byte(testIP2ASN), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := loadIP2ASN(path)
+	if err != nil {
+		t.Fatalf("loadIP2ASN failed: %v", err)
+	}
+
+	tests := []struct {
+		ip       string
+		expected Info
+		found    bool
+	}{
+		{ip: "1.0.0.1", expected: Info{ASN: "13335", ASName: "CLOUDFLARENET", CountryCode: "US"}, found: true},
+		{ip: "8.8.8.255", expected: Info{ASN: "15169", ASName: "GOOGLE", CountryCode: "US"}, found: true},
+		{ip: "2001:4860:4860::8888", expected: Info{ASN: "15169", ASName: "GOOGLE", CountryCode: "US"}, found: true},
+		{ip: "1.0.2.1", found: false}, // Not routed
+		{ip: "8.8.9.1", found: false}, // Between ranges
+		{ip: "0.0.0.1", found: false}, // Before the first range
+		{ip: "2001:db8::1", found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			info, found := src.lookup(netip.MustParseAddr(tt.ip))
+			if found != tt.found {
+				t.Fatalf("Expected found=%v, got %v", tt.found, found)
+			}
+			if info != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, info)
+			}
+		})
+	}
+}
+
+func TestLoadIP2ASNGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip2asn.tsv.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write([]byte(testIP2ASN)); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	f.Close()
+
+	src, err := loadIP2ASN(path)
+	if err != nil {
+		t.Fatalf("loadIP2ASN failed: %v", err)
+	}
+	if info, found := src.lookup(netip.MustParseAddr("8.8.8.8")); !found || info.ASN != "15169" {
+		t.Errorf("Expected AS15169, got %+v", info)
+	}
+}
+
+func TestLoadIP2ASNInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	if err := os.WriteFile(path, []byte("not-an-ip\t1.0.0.255\t13335\tUS\tX\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadIP2ASN(path); err == nil {
+		t.Error("Expected error for invalid address")
+	}
+}
@@ -0,0 +1,75 @@
+package enrich
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// mmdbRecord holds the fields of the GeoLite2/GeoIP2 ASN, Country and City
+// databases. Each database only fills the fields it knows about.
+type mmdbRecord struct {
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+	City  struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// mmdbSource is a MaxMind format database
+type mmdbSource struct {
+	reader *maxminddb.Reader
+}
+
+// loadMMDB reads a MaxMind format database into memory, so the file can be
+// replaced while it is in use
+func loadMMDB(path string) (source, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind database: %w", err)
+	}
+	return &mmdbSource{reader: reader}, nil
+}
+
+// lookup implements source
+func (s *mmdbSource) lookup(addr netip.Addr) (Info, bool) {
+	var record mmdbRecord
+	_, found, err := s.reader.LookupNetwork(addr.AsSlice(), &record)
+	if err != nil || !found {
+		return Info{}, false
+	}
+
+	info := Info{
+		ASName:      record.ASOrg,
+		CountryCode: record.Country.ISOCode,
+		Latitude:    record.Location.Latitude,
+		Longitude:   record.Location.Longitude,
+	}
+	if record.ASN > 0 {
+		info.ASN = strconv.FormatUint(uint64(record.ASN), 10)
+	}
+
+	// Same format as the locations reported by nexttrace
+	city, country := record.City.Names["en"], record.Country.Names["en"]
+	if city != "" && country != "" {
+		info.Location = city + ", " + country
+	} else {
+		info.Location = country
+	}
+	return info, true
+}
@@ -0,0 +1,212 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// mmdbNode is a node of the search tree built by writeMMDB
+type mmdbNode struct {
+	children [2]*mmdbNode
+	data     int // Offset into the data section for leaves, -1 otherwise
+}
+
+// writeMMDB writes a minimal IPv4 MaxMind database with 24 bit records
+// mapping each prefix to its record
+func writeMMDB(t *testing.T, records map[string]map[string]any) string {
+	t.Helper()
+
+	var data bytes.Buffer
+	root := &mmdbNode{data: -1}
+	for prefix, record := range records {
+		p := netip.MustParsePrefix(prefix)
+		addr := p.Addr().As4()
+
+		node := root
+		for bit := 0; bit < p.Bits(); bit++ {
+			side := (addr[bit/8] >> (7 - bit%8)) & 1
+			if node.children[side] == nil {
+				node.children[side] = &mmdbNode{data: -1}
+			}
+			node = node.children[side]
+		}
+		node.data = data.Len()
+		data.Write(encodeMMDB(record))
+	}
+
+	// Number the inner nodes breadth first, the root being node 0
+	var nodes []*mmdbNode
+	index := make(map[*mmdbNode]int)
+	queue := []*mmdbNode{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node.data >= 0 {
+			continue
+		}
+		index[node] = len(nodes)
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	nodeCount := len(nodes)
+	for _, node := range nodes {
+		for _, child := range node.children {
+			value := nodeCount // Not found
+			if child != nil && child.data >= 0 {
+				value = nodeCount + 16 + child.data
+			} else if child != nil {
+				value = index[child]
+			}
+			out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xAB\xCD\xEFMaxMind.com")
+	out.Write(encodeMMDB(map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(0),
+		"database_type":               "Test",
+		"description":                 map[string]any{},
+		"ip_version":                  uint16(4),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	}))
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// encodeMMDB encodes a value in the MaxMind DB data section format
+func encodeMMDB(value any) []byte {
+	var out bytes.Buffer
+	switch v := value.(type) {
+	case string:
+		out.Write(mmdbControl(2, len(v)))
+		out.WriteString(v)
+	case float64:
+		out.Write(mmdbControl(3, 8))
+		_ = binary.Write(&out, binary.BigEndian, math.Float64bits(v))
+	case uint16:
+		out.Write(mmdbUint(5, uint64(v)))
+	case uint32:
+		out.Write(mmdbUint(6, uint64(v)))
+	case uint64:
+		out.Write(mmdbUint(9, v))
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		out.Write(mmdbControl(7, len(v)))
+		for _, key := range keys {
+			out.Write(encodeMMDB(key))
+			out.Write(encodeMMDB(v[key]))
+		}
+	case []any:
+		out.Write(mmdbControl(11, len(v)))
+		for _, item := range v {
+			out.Write(encodeMMDB(item))
+		}
+	default:
+		panic("unsupported type")
+	}
+	return out.Bytes()
+}
+
+// mmdbUint encodes an unsigned integer with as few bytes as possible
+func mmdbUint(typ int, v uint64) []byte {
+	var payload []byte
+	for ; v > 0; v >>= 8 {
+		payload = append([]byte{byte(v)}, payload...)
+	}
+	return append(mmdbControl(typ, len(payload)), payload...)
+}
+
+// mmdbControl encodes the control byte(s) of a field
+func mmdbControl(typ, size int) []byte {
+	var out []byte
+	if typ > 7 {
+		out = []byte{byte(size & 0x1f), byte(typ - 7)}
+	} else {
+		out = []byte{byte(typ<<5 | size&0x1f)}
+	}
+	if size >= 29 {
+		out[0] = out[0]&^0x1f | 29
+		out = append(out, byte(size-29))
+	}
+	return out
+}
+
+func TestMMDBLookup(t *testing.T) {
+	path := writeMMDB(t, map[string]map[string]any{
+		"8.8.8.0/24": {
+			"autonomous_system_number":       uint32(15169),
+			"autonomous_system_organization": "GOOGLE",
+		},
+		"1.1.1.0/24": {
+			"city":     map[string]any{"names": map[string]any{"en": "Sydney"}},
+			"country":  map[string]any{"iso_code": "AU", "names": map[string]any{"en": "Australia"}},
+			"location": map[string]any{"latitude": -33.8688, "longitude": 151.209},
+		},
+		"9.9.9.0/24": {
+			"country": map[string]any{"iso_code": "CH", "names": map[string]any{"en": "Switzerland"}},
+		},
+	})
+
+	src, err := loadMMDB(path)
+	if err != nil {
+		t.Fatalf("loadMMDB failed: %v", err)
+	}
+
+	tests := []struct {
+		ip       string
+		expected Info
+		found    bool
+	}{
+		{ip: "8.8.8.8", expected: Info{ASN: "15169", ASName: "GOOGLE"}, found: true},
+		{ip: "1.1.1.1", expected: Info{CountryCode: "AU", Location: "Sydney, Australia", Latitude: -33.8688, Longitude: 151.209}, found: true},
+		{ip: "9.9.9.9", expected: Info{CountryCode: "CH", Location: "Switzerland"}, found: true},
+		{ip: "192.168.1.1", found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			info, found := src.lookup(netip.MustParseAddr(tt.ip))
+			if found != tt.found {
+				t.Fatalf("Expected found=%v, got %v", tt.found, found)
+			}
+			if info != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, info)
+			}
+		})
+	}
+}
+
+func TestLoadMMDBInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.mmdb")
+	if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadMMDB(path); err == nil {
+		t.Error("Expected error for invalid database")
+	}
+}
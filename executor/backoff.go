@@ -0,0 +1,96 @@
+package executor
+
+import (
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // The target runs on its interval
+	BreakerOpen     = "open"      // The target is paused until the cooldown ends
+	BreakerHalfOpen = "half_open" // A single run decides whether the target resumes
+)
+
+// BreakerStates lists every circuit breaker state, in the order they are
+// exported
+var BreakerStates = []string{BreakerClosed, BreakerOpen, BreakerHalfOpen}
+
+// BackoffState describes how the failures of a target affect its schedule
+type BackoffState struct {
+	Failures int           // Consecutive failed runs
+	Backoff  time.Duration // Delay added to the next run
+	Breaker  string        // Circuit breaker state, see BreakerStates
+}
+
+// failureTracker counts the consecutive failures of a target to compute its
+// backoff and circuit breaker state
+type failureTracker struct {
+	BackoffState
+	breakerFailures int // Consecutive failures with a reason that opens the breaker
+}
+
+// failed reports whether a run counts as a failure. Partial traces still
+// tell something about the route, so they don't.
+func failed(result *ExecutionResult) bool {
+	return result.Status != StatusSuccess && result.Status != StatusPartial
+}
+
+// record updates the state with the result of a run and returns the new
+// circuit breaker state
+func (f *failureTracker) record(result *ExecutionResult, backoff config.BackoffConfig, breaker config.CircuitBreakerConfig) string {
+	if !failed(result) {
+		*f = failureTracker{BackoffState: BackoffState{Breaker: BreakerClosed}}
+		return f.Breaker
+	}
+
+	f.Failures++
+	f.Backoff = backoffDelay(f.Failures, backoff)
+	if f.Breaker == "" {
+		f.Breaker = BreakerClosed
+	}
+
+	if breakerReason(result.Reason, breaker) {
+		f.breakerFailures++
+	} else {
+		f.breakerFailures = 0
+	}
+
+	switch {
+	case breaker.Disabled || breaker.Threshold <= 0:
+		f.Breaker = BreakerClosed
+	case f.Breaker == BreakerHalfOpen, f.breakerFailures >= breaker.Threshold:
+		// A failed retry pauses the target again, whatever the reason
+		f.Breaker = BreakerOpen
+	}
+	return f.Breaker
+}
+
+// backoffDelay returns the delay after the given number of consecutive
+// failures: the initial delay, doubled for each further failure up to max
+func backoffDelay(failures int, cfg config.BackoffConfig) time.Duration {
+	if cfg.Disabled || cfg.Initial <= 0 || failures < 1 {
+		return 0
+	}
+
+	delay := cfg.Initial
+	for i := 1; i < failures && delay < cfg.Max; i++ {
+		delay *= 2
+	}
+	if delay > cfg.Max {
+		delay = cfg.Max
+	}
+	return delay
+}
+
+// breakerReason reports whether failures with reason count towards opening
+// the circuit breaker
+func breakerReason(reason string, cfg config.CircuitBreakerConfig) bool {
+	for _, r := range cfg.Reasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
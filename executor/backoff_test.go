@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	cfg := config.BackoffConfig{Initial: 30 * time.Second, Max: 5 * time.Minute}
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 0, expected: 0},
+		{failures: 1, expected: 30 * time.Second},
+		{failures: 2, expected: time.Minute},
+		{failures: 4, expected: 4 * time.Minute},
+		{failures: 5, expected: 5 * time.Minute},
+		{failures: 1000, expected: 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if delay := backoffDelay(tt.failures, cfg); delay != tt.expected {
+			t.Errorf("%d failures: expected %v, got %v", tt.failures, tt.expected, delay)
+		}
+	}
+
+	cfg.Disabled = true
+	if delay := backoffDelay(3, cfg); delay != 0 {
+		t.Errorf("Expected no delay when disabled, got %v", delay)
+	}
+}
+
+func TestFailureTracker(t *testing.T) {
+	backoff := config.BackoffConfig{Initial: time.Second, Max: time.Minute}
+	breaker := config.CircuitBreakerConfig{Threshold: 3, Cooldown: time.Hour, Reasons: []string{ReasonDNSFailure}}
+
+	failure := func(reason string) *ExecutionResult {
+		return &ExecutionResult{Status: StatusError, Reason: reason}
+	}
+
+	steps := []struct {
+		name     string
+		result   *ExecutionResult
+		breaker  string
+		failures int
+		backoff  time.Duration
+	}{
+		{name: "dns failure", result: failure(ReasonDNSFailure), breaker: BreakerClosed, failures: 1, backoff: time.Second},
+		{name: "dns failure", result: failure(ReasonDNSFailure), breaker: BreakerClosed, failures: 2, backoff: 2 * time.Second},
+		// Other reasons back off but restart the breaker count
+		{name: "timeout", result: &ExecutionResult{Status: StatusTimeout, Reason: ReasonTimeout}, breaker: BreakerClosed, failures: 3, backoff: 4 * time.Second},
+		{name: "dns failure", result: failure(ReasonDNSFailure), breaker: BreakerClosed, failures: 4, backoff: 8 * time.Second},
+		{name: "dns failure", result: failure(ReasonDNSFailure), breaker: BreakerClosed, failures: 5, backoff: 16 * time.Second},
+		{name: "dns failure", result: failure(ReasonDNSFailure), breaker: BreakerOpen, failures: 6, backoff: 32 * time.Second},
+		// Partial traces reset everything
+		{name: "partial", result: &ExecutionResult{Status: StatusPartial, Reason: ReasonTimeout}, breaker: BreakerClosed},
+	}
+
+	var f failureTracker
+	for i, step := range steps {
+		state := f.record(step.result, backoff, breaker)
+		if state != step.breaker || f.Failures != step.failures || f.Backoff != step.backoff {
+			t.Errorf("Step %d (%s): expected %s with %d failures and %v backoff, got %s with %d and %v",
+				i, step.name, step.breaker, step.failures, step.backoff, state, f.Failures, f.Backoff)
+		}
+	}
+
+	// A failed retry while half-open reopens the breaker for any reason
+	f.Breaker = BreakerHalfOpen
+	if state := f.record(failure(ReasonUnknown), backoff, breaker); state != BreakerOpen {
+		t.Errorf("Expected failed retry to reopen the breaker, got %s", state)
+	}
+
+	breaker.Disabled = true
+	f = failureTracker{}
+	for i := 0; i < 5; i++ {
+		f.record(failure(ReasonDNSFailure), backoff, breaker)
+	}
+	if f.Breaker != BreakerClosed {
+		t.Errorf("Expected disabled breaker to stay closed, got %s", f.Breaker)
+	}
+}
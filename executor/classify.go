@@ -0,0 +1,104 @@
+package executor
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/vinsec/nexttrace_exporter/tracer"
+)
+
+// Error reasons, telling why an execution failed
+const (
+	ReasonTimeout            = "timeout"
+	ReasonBinaryNotFound     = "binary_not_found"
+	ReasonPermissionDenied   = "permission_denied"
+	ReasonDNSFailure         = "dns_failure"
+	ReasonNetworkUnreachable = "network_unreachable"
+	ReasonParseError         = "parse_error"
+	ReasonUnknown            = "unknown"
+)
+
+// Reasons lists every error reason, in the order they are exported
+var Reasons = []string{
+	ReasonTimeout,
+	ReasonBinaryNotFound,
+	ReasonPermissionDenied,
+	ReasonDNSFailure,
+	ReasonNetworkUnreachable,
+	ReasonParseError,
+	ReasonUnknown,
+}
+
+// outputPatterns maps messages printed by nexttrace, mtr and traceroute to
+// error reasons. Patterns are lower case and checked in order.
+var outputPatterns = []struct {
+	pattern string
+	reason  string
+}{
+	{"command not found", ReasonBinaryNotFound},
+	{"operation not permitted", ReasonPermissionDenied},
+	{"permission denied", ReasonPermissionDenied},
+	{"cap_net_raw", ReasonPermissionDenied},
+	{"must be root", ReasonPermissionDenied},
+	{"requires root", ReasonPermissionDenied},
+	{"no such host", ReasonDNSFailure},
+	{"name or service not known", ReasonDNSFailure},
+	{"temporary failure in name resolution", ReasonDNSFailure},
+	{"nodename nor servname provided", ReasonDNSFailure},
+	{"failed to resolve", ReasonDNSFailure},
+	{"cannot resolve", ReasonDNSFailure},
+	{"unknown host", ReasonDNSFailure},
+	{"network is unreachable", ReasonNetworkUnreachable},
+	{"no route to host", ReasonNetworkUnreachable},
+	{"host is unreachable", ReasonNetworkUnreachable},
+}
+
+// classifyError returns the reason of a failed execution from the error and
+// the output of the trace tool, or ReasonUnknown if it is not recognized
+func classifyError(err error, output []byte) string {
+	var (
+		parseErr *tracer.ParseError
+		dnsErr   *net.DNSError
+		exitErr  *exec.ExitError
+	)
+
+	// Errors of the exporter itself, e.g. from starting the binary or from
+	// the native backend
+	switch {
+	case errors.As(err, &parseErr):
+		return ReasonParseError
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return ReasonBinaryNotFound
+	case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EACCES):
+		return ReasonPermissionDenied
+	case errors.As(err, &dnsErr):
+		return ReasonDNSFailure
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return ReasonNetworkUnreachable
+	}
+
+	// Messages of the trace tool
+	lower := strings.ToLower(string(output))
+	for _, p := range outputPatterns {
+		if strings.Contains(lower, p.pattern) {
+			return p.reason
+		}
+	}
+
+	// Shells and wrapper scripts report a missing or non-executable program
+	// through the exit code
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case 126:
+			return ReasonPermissionDenied
+		case 127:
+			return ReasonBinaryNotFound
+		}
+	}
+
+	return ReasonUnknown
+}
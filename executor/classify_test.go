@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/tracer"
+)
+
+func TestReasonsMatchConfig(t *testing.T) {
+	// The circuit breaker settings are validated against the config list
+	if !reflect.DeepEqual(Reasons, config.ErrorReasons) {
+		t.Errorf("Expected config.ErrorReasons %v to match Reasons %v", config.ErrorReasons, Reasons)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	// Real errors from starting and running commands
+	_, notFoundErr := exec.Command("/nonexistent/nexttrace").CombinedOutput()
+	_, notInPathErr := exec.Command("nexttrace-does-not-exist").CombinedOutput()
+	_, exit127Err := exec.Command("sh", "-c", "exit 127").CombinedOutput()
+	_, exit1Err := exec.Command("sh", "-c", "exit 1").CombinedOutput()
+
+	tests := []struct {
+		name     string
+		err      error
+		output   string
+		expected string
+	}{
+		{
+			name:     "parse error",
+			err:      &tracer.ParseError{Err: errors.New("unexpected end of JSON input")},
+			expected: ReasonParseError,
+		},
+		{
+			name:     "missing binary path",
+			err:      &tracer.CommandError{Err: notFoundErr},
+			expected: ReasonBinaryNotFound,
+		},
+		{
+			name:     "binary not in PATH",
+			err:      &tracer.CommandError{Err: notInPathErr},
+			expected: ReasonBinaryNotFound,
+		},
+		{
+			name:     "exit code 127",
+			err:      &tracer.CommandError{Err: exit127Err},
+			expected: ReasonBinaryNotFound,
+		},
+		{
+			name:     "native socket without privileges",
+			err:      fmt.Errorf("failed to open raw ICMP socket: %w", syscall.EPERM),
+			expected: ReasonPermissionDenied,
+		},
+		{
+			name:     "native DNS failure",
+			err:      fmt.Errorf("failed to resolve example.invalid: %w", &net.DNSError{Err: "no such host", Name: "example.invalid"}),
+			expected: ReasonDNSFailure,
+		},
+		{
+			name:     "native unreachable network",
+			err:      fmt.Errorf("failed to send probe: %w", syscall.ENETUNREACH),
+			expected: ReasonNetworkUnreachable,
+		},
+		{
+			name:     "nexttrace without CAP_NET_RAW",
+			err:      &tracer.CommandError{Err: exit1Err},
+			output:   "listen ip4:icmp 0.0.0.0: socket: operation not permitted",
+			expected: ReasonPermissionDenied,
+		},
+		{
+			name:     "mtr DNS failure",
+			err:      &tracer.CommandError{Err: exit1Err},
+			output:   "mtr: Failed to resolve host: example.invalid: Name or service not known",
+			expected: ReasonDNSFailure,
+		},
+		{
+			name:     "traceroute unknown host",
+			err:      &tracer.CommandError{Err: exit1Err},
+			output:   "example.invalid: Temporary failure in name resolution\nCannot handle \"host\" cmdline arg `example.invalid' on position 1 (argc 1)",
+			expected: ReasonDNSFailure,
+		},
+		{
+			name:     "traceroute unreachable network",
+			err:      &tracer.CommandError{Err: exit1Err},
+			output:   "connect: Network is unreachable",
+			expected: ReasonNetworkUnreachable,
+		},
+		{
+			name:     "unknown",
+			err:      &tracer.CommandError{Err: exit1Err},
+			output:   "something went wrong",
+			expected: ReasonUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := classifyError(tt.err, []byte(tt.output))
+			if reason != tt.expected {
+				t.Errorf("Expected reason %s, got %s", tt.expected, reason)
+			}
+		})
+	}
+}
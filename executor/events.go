@@ -0,0 +1,135 @@
+package executor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// Event types
+const (
+	EventTraceStarted  = "trace_started"
+	EventHopDiscovered = "hop_discovered"
+	EventTraceFinished = "trace_finished"
+	EventRouteChanged  = "route_changed"
+)
+
+// DefaultEventBuffer is the number of events queued for a subscriber by
+// default before further events are dropped
+const DefaultEventBuffer = 256
+
+// Event describes progress of the executions of a target
+type Event struct {
+	Type      string    `json:"type"`
+	Target    string    `json:"target"`
+	Timestamp time.Time `json:"timestamp"`
+	// Host and Backend are set on trace_started
+	Host    string `json:"host,omitempty"`
+	Backend string `json:"backend,omitempty"`
+	// Hop is set on hop_discovered
+	Hop *parser.Hop `json:"hop,omitempty"`
+	// Result is set on trace_finished
+	Result *ExecutionResult `json:"result,omitempty"`
+	// Route fingerprints and the TTLs that differ are set on route_changed
+	OldFingerprint string `json:"old_fingerprint,omitempty"`
+	NewFingerprint string `json:"new_fingerprint,omitempty"`
+	ChangedTTLs    []int  `json:"changed_ttls,omitempty"`
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	events  chan Event
+	targets map[string]bool // Empty to receive the events of all targets
+	dropped uint64
+	bus     *eventBus
+}
+
+// Events returns the channel delivering the events. It is closed when the
+// subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns how many events were dropped because the subscriber did
+// not keep up
+func (s *Subscription) Dropped() uint64 {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.dropped
+}
+
+// Close stops the delivery of events and releases the subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, exists := s.bus.subscribers[s]; exists {
+		delete(s.bus.subscribers, s)
+		close(s.events)
+	}
+}
+
+// eventBus fans out events to subscribers. Publishing never blocks: events
+// for a subscriber whose buffer is full are dropped.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// newEventBus creates an event bus without subscribers
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// subscribe registers a subscriber for the events of targets, or of all
+// targets if none are given
+func (b *eventBus) subscribe(buffer int, targets []string) *Subscription {
+	if buffer < 1 {
+		buffer = DefaultEventBuffer
+	}
+
+	sub := &Subscription{
+		events:  make(chan Event, buffer),
+		targets: make(map[string]bool, len(targets)),
+		bus:     b,
+	}
+	for _, target := range targets {
+		sub.targets[target] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// publish delivers an event to every subscriber interested in its target
+func (b *eventBus) publish(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if len(sub.targets) > 0 && !sub.targets[event.Target] {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events of the given targets, or
+// of all targets if none are given. Up to buffer events are queued for a
+// slow subscriber (DefaultEventBuffer if buffer is not positive); further
+// events are dropped until it catches up. The subscription must be closed
+// when no longer needed.
+func (e *Executor) Subscribe(buffer int, targets ...string) *Subscription {
+	return e.events.subscribe(buffer, targets)
+}
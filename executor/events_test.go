@@ -0,0 +1,141 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// drain returns the events queued for a subscription
+func drain(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case event := <-sub.Events():
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestEventsOnStore(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	e := NewExecutor("nexttrace", time.Minute, logger)
+
+	all := e.Subscribe(0)
+	defer all.Close()
+	onlyB := e.Subscribe(0, "b")
+	defer onlyB.Close()
+
+	route := func(ip string) *parser.NextTraceResult {
+		return &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "192.168.1.1"}, {TTL: 2, IP: ip}}}
+	}
+	e.SetTestResult("a", route("10.0.0.1"), time.Second)
+	e.SetTestResult("a", route("10.0.0.2"), time.Second)
+	e.SetTestFailure("b", StatusTimeout, ReasonTimeout)
+
+	var types []string
+	for _, event := range drain(all) {
+		types = append(types, event.Target+":"+event.Type)
+		if event.Timestamp.IsZero() {
+			t.Errorf("Expected timestamp on %s event", event.Type)
+		}
+		if event.Type == EventRouteChanged {
+			if event.OldFingerprint == event.NewFingerprint || !reflect.DeepEqual(event.ChangedTTLs, []int{2}) {
+				t.Errorf("Unexpected route change event: %+v", event)
+			}
+		}
+	}
+
+	expected := []string{
+		"a:" + EventTraceFinished,
+		"a:" + EventTraceFinished,
+		"a:" + EventRouteChanged,
+		"b:" + EventTraceFinished,
+	}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("Expected events %v, got %v", expected, types)
+	}
+
+	filtered := drain(onlyB)
+	if len(filtered) != 1 || filtered[0].Target != "b" || filtered[0].Result.Status != StatusTimeout {
+		t.Errorf("Expected only the event of b, got %+v", filtered)
+	}
+}
+
+func TestEventBufferOverflow(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(2, nil)
+
+	// Publishing must not block on a subscriber that doesn't read
+	for i := 0; i < 5; i++ {
+		bus.publish(Event{Type: EventTraceStarted, Target: "a"})
+	}
+
+	if events := drain(sub); len(events) != 2 {
+		t.Errorf("Expected 2 buffered events, got %d", len(events))
+	}
+	if dropped := sub.Dropped(); dropped != 3 {
+		t.Errorf("Expected 3 dropped events, got %d", dropped)
+	}
+
+	sub.Close()
+	sub.Close()
+	if _, open := <-sub.Events(); open {
+		t.Error("Expected events channel to be closed")
+	}
+
+	// Closed subscriptions no longer receive events
+	bus.publish(Event{Type: EventTraceStarted, Target: "a"})
+	if len(bus.subscribers) != 0 {
+		t.Errorf("Expected no subscribers, got %d", len(bus.subscribers))
+	}
+}
+
+func TestHopEventsEnriched(t *testing.T) {
+	ip2asn := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	if err := os.WriteFile(ip2asn, []byte("8.8.8.0\t8.8.8.255\t15169\tUS\tGOOGLE\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewExecutor("nexttrace", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	e.ConfigureEnrichment(config.EnrichmentConfig{IP2ASNFile: ip2asn, Override: true})
+	e.tracers["fake"] = &fakeTracer{
+		result: &parser.NextTraceResult{
+			TargetIP: "8.8.8.8",
+			Hops:     []parser.Hop{{TTL: 1, IP: "8.8.8.8", Responders: []parser.Responder{{IP: "8.8.8.8", Share: 1}}}},
+		},
+		stream: true,
+	}
+
+	sub := e.Subscribe(0, "a")
+	defer sub.Close()
+	result := e.executeTarget(context.Background(), config.Target{Name: "a", Host: "8.8.8.8", Backend: "fake"})
+
+	var hop *parser.Hop
+	for _, event := range drain(sub) {
+		if event.Type == EventHopDiscovered {
+			hop = event.Hop
+		}
+	}
+	if hop == nil {
+		t.Fatal("Expected a hop_discovered event")
+	}
+	if hop.ASName != "GOOGLE" || hop.Responders[0].ASName != "GOOGLE" {
+		t.Errorf("Expected enriched hop and responder, got %q and %q", hop.ASName, hop.Responders[0].ASName)
+	}
+
+	// The event doesn't share responders with the stored result
+	if &hop.Responders[0] == &result.Result.Hops[0].Responders[0] {
+		t.Error("Expected the event to carry its own copy of the responders")
+	}
+}
@@ -1,15 +1,23 @@
 package executor
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os/exec"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/enrich"
 	"github.com/vinsec/nexttrace_exporter/parser"
+	"github.com/vinsec/nexttrace_exporter/tracer"
 )
 
 // ExecutionResult stores the result of a nexttrace execution
@@ -19,98 +27,419 @@ type ExecutionResult struct {
 	Duration  time.Duration
 	Timestamp time.Time
 	Error     error
-	Status    string // "success", "error", "timeout"
+	Status    string // "success", "partial", "error", "timeout", "parse_error"
+	Reason    string // Why the execution failed, see Reasons; empty on success
+	Stderr    string // End of the standard error of a failed trace tool
+}
+
+// executionResultJSON is the JSON representation of an ExecutionResult
+type executionResultJSON struct {
+	Target          string                  `json:"target"`
+	Status          string                  `json:"status"`
+	Timestamp       time.Time               `json:"timestamp"`
+	DurationSeconds float64                 `json:"duration_seconds"`
+	Error           string                  `json:"error,omitempty"`
+	Reason          string                  `json:"reason,omitempty"`
+	Stderr          string                  `json:"stderr,omitempty"`
+	Result          *parser.NextTraceResult `json:"result,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (r *ExecutionResult) MarshalJSON() ([]byte, error) {
+	out := executionResultJSON{
+		Target:          r.Target,
+		Status:          r.Status,
+		Timestamp:       r.Timestamp,
+		DurationSeconds: r.Duration.Seconds(),
+		Reason:          r.Reason,
+		Stderr:          r.Stderr,
+		Result:          r.Result,
+	}
+	if r.Error != nil {
+		out.Error = r.Error.Error()
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *ExecutionResult) UnmarshalJSON(data []byte) error {
+	var in executionResultJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	*r = ExecutionResult{
+		Target:    in.Target,
+		Status:    in.Status,
+		Timestamp: in.Timestamp,
+		Duration:  time.Duration(in.DurationSeconds * float64(time.Second)),
+		Reason:    in.Reason,
+		Stderr:    in.Stderr,
+		Result:    in.Result,
+	}
+	if in.Error != "" {
+		r.Error = errors.New(in.Error)
+	}
+	// Failures stored before reasons were introduced
+	if r.Status != StatusSuccess && r.Reason == "" {
+		r.Reason = ReasonUnknown
+	}
+	return nil
+}
+
+// resolveTimeout bounds the lookup of a target's address after a trace
+const resolveTimeout = 2 * time.Second
+
+// stderrTailSize bounds the end of the standard error of a trace tool kept
+// in a result
+const stderrTailSize = 4096
+
+// DefaultHistorySize is the number of results kept per target by default
+const DefaultHistorySize = 10
+
+// DefaultGracePeriod is how long Stop and Reload wait by default for the
+// runs they cancelled to end
+const DefaultGracePeriod = 10 * time.Second
+
+// DefaultTriggerInterval is the minimum time between manual triggers of a
+// target by default
+const DefaultTriggerInterval = 30 * time.Second
+
+// Execution statuses
+const (
+	StatusSuccess    = "success"
+	StatusPartial    = "partial" // Timed out or failed, keeping the hops completed until then
+	StatusError      = "error"
+	StatusTimeout    = "timeout"
+	StatusParseError = "parse_error"
+)
+
+// Statuses lists every execution status, in the order they are exported
+var Statuses = []string{StatusSuccess, StatusPartial, StatusError, StatusTimeout, StatusParseError}
+
+// RouteState tracks the route seen for a target across executions
+type RouteState struct {
+	Fingerprint string
+	Changes     uint64
+	LastChange  time.Time               // Zero until the route has changed at least once
+	last        *parser.NextTraceResult // Last route, with silent hops filled from earlier ones
 }
 
 // Executor manages the execution of nexttrace commands for multiple targets
 type Executor struct {
-	binaryPath      string
+	tracers         map[string]tracer.Tracer
+	enricher        *enrich.Enricher
+	events          *eventBus
 	timeout         time.Duration
 	results         map[string]*ExecutionResult
+	counts          map[string]map[string]uint64
+	reasonCounts    map[string]map[string]uint64
+	routes          map[string]*RouteState
+	history         map[string][]*ExecutionResult
+	historySize     int
+	triggerInterval time.Duration
+	gracePeriod     time.Duration
+	inflight        sync.WaitGroup     // Runs in progress, including probes
+	stopCtx         context.Context    // Done once Stop was called
+	stopAll         context.CancelFunc // Ends every run in progress
 	resultsMutex    sync.RWMutex
+	statePath       string
+	saveRequests    chan struct{} // Wakes the state writer, see requestSave
+	saveQuit        chan struct{} // Closed by Stop to make the writer flush and exit
+	saveDone        chan struct{} // Closed once the writer has exited
 	targets         []config.Target
-	cancelFuncs     map[string]context.CancelFunc
-	cancelFuncMutex sync.Mutex
+	scheduler       *scheduler
+	reloadMutex     sync.Mutex
 	logger          *slog.Logger
 }
 
+// ReloadDiff describes how the target list changed on reload
+type ReloadDiff struct {
+	Added     []string
+	Removed   []string
+	Changed   []string
+	Unchanged []string
+}
+
 // NewExecutor creates a new Executor instance
 func NewExecutor(binaryPath string, timeout time.Duration, logger *slog.Logger) *Executor {
-	return &Executor{
-		binaryPath:  binaryPath,
-		timeout:     timeout,
-		results:     make(map[string]*ExecutionResult),
-		cancelFuncs: make(map[string]context.CancelFunc),
-		logger:      logger,
+	stopCtx, stopAll := context.WithCancel(context.Background())
+	e := &Executor{
+		tracers: map[string]tracer.Tracer{
+			config.BackendNextTrace:  tracer.NewNextTrace(binaryPath),
+			config.BackendMTR:        tracer.NewMTR("mtr"),
+			config.BackendTraceroute: tracer.NewTraceroute("traceroute"),
+			config.BackendNative:     tracer.NewNative(),
+		},
+		enricher:        enrich.New(logger),
+		events:          newEventBus(),
+		timeout:         timeout,
+		results:         make(map[string]*ExecutionResult),
+		counts:          make(map[string]map[string]uint64),
+		reasonCounts:    make(map[string]map[string]uint64),
+		routes:          make(map[string]*RouteState),
+		history:         make(map[string][]*ExecutionResult),
+		historySize:     DefaultHistorySize,
+		triggerInterval: DefaultTriggerInterval,
+		gracePeriod:     DefaultGracePeriod,
+		saveRequests:    make(chan struct{}, 1),
+		saveQuit:        make(chan struct{}),
+		stopCtx:         stopCtx,
+		stopAll:         stopAll,
+		logger:          logger,
 	}
+	e.scheduler = newScheduler(e.executeTarget, logger)
+	return e
+}
+
+// Configure applies the scheduler settings. It can be called at any time;
+// changes apply to the next runs that get scheduled.
+func (e *Executor) Configure(cfg config.SchedulerConfig) {
+	e.scheduler.configure(cfg)
+}
+
+// ConfigureEnrichment sets the local databases used to add ASN and geo data
+// to trace results. Like Configure it can be called at any time.
+func (e *Executor) ConfigureEnrichment(cfg config.EnrichmentConfig) {
+	e.enricher.Configure(cfg)
+}
+
+// SetHistorySize sets how many results are kept per target. It must be
+// called before Start.
+func (e *Executor) SetHistorySize(size int) {
+	e.resultsMutex.Lock()
+	defer e.resultsMutex.Unlock()
+
+	if size < 1 {
+		size = 1
+	}
+	e.historySize = size
+}
+
+// SetMaxOutput sets how many bytes of the standard output and of the
+// standard error of trace tools are kept per run. It must be called before
+// Start.
+func (e *Executor) SetMaxOutput(size int) {
+	for _, t := range e.tracers {
+		if cmd, ok := t.(*tracer.Command); ok {
+			cmd.MaxOutput = size
+		}
+	}
+}
+
+// SetTriggerInterval sets the minimum time between manual triggers of a
+// target. It must be called before Start.
+func (e *Executor) SetTriggerInterval(interval time.Duration) {
+	e.triggerInterval = interval
+}
+
+// SetGracePeriod sets how long Stop and Reload wait for the runs they
+// cancelled, and the trace tools they started, to end. It must be called
+// before Start.
+func (e *Executor) SetGracePeriod(period time.Duration) {
+	e.gracePeriod = period
+}
+
+// Timeout returns the maximum duration of a single trace
+func (e *Executor) Timeout() time.Duration {
+	return e.timeout
 }
 
 // Start begins executing nexttrace for all configured targets
 func (e *Executor) Start(ctx context.Context, targets []config.Target) {
+	e.reloadMutex.Lock()
+	defer e.reloadMutex.Unlock()
+
 	e.targets = targets
 
+	// State restored from storage may belong to targets no longer configured
+	names := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		names[target.Name] = true
+	}
+	e.pruneState(names)
+
 	for _, target := range targets {
-		go e.runTargetLoop(ctx, target)
+		e.scheduler.add(ctx, target)
 	}
+	e.scheduler.start(ctx)
 }
 
-// Stop gracefully stops all running executions
+// Stop cancels all running executions, including probes, and waits up to
+// the grace period for them to end. The executor can't be started again.
 func (e *Executor) Stop() {
-	e.cancelFuncMutex.Lock()
-	defer e.cancelFuncMutex.Unlock()
+	e.reloadMutex.Lock()
+	defer e.reloadMutex.Unlock()
 
-	for _, cancel := range e.cancelFuncs {
-		cancel()
+	var stopping []<-chan struct{}
+	for _, name := range e.scheduler.names() {
+		stopping = append(stopping, e.scheduler.remove(name))
 	}
-	e.cancelFuncs = make(map[string]context.CancelFunc)
-}
+	e.stopAll()
 
-// runTargetLoop runs nexttrace for a single target in a loop
-func (e *Executor) runTargetLoop(ctx context.Context, target config.Target) {
-	ticker := time.NewTicker(target.Interval)
-	defer ticker.Stop()
+	inflight := make(chan struct{})
+	go func() {
+		e.inflight.Wait()
+		close(inflight)
+	}()
+	e.waitStopped(append(stopping, inflight))
+	e.stopStorage()
+}
 
-	// Execute immediately on start
-	e.executeTarget(ctx, target)
+// waitStopped waits up to the grace period until every channel of runs that
+// were cancelled is closed
+func (e *Executor) waitStopped(stopping []<-chan struct{}) {
+	timer := time.NewTimer(e.gracePeriod)
+	defer timer.Stop()
 
-	for {
+	for _, done := range stopping {
+		if done == nil {
+			continue
+		}
 		select {
-		case <-ctx.Done():
-			e.logger.Info("Stopping execution loop for target",
-				"target", target.Name,
-				"host", target.Host)
+		case <-done:
+		case <-timer.C:
+			e.logger.Warn("Cancelled executions did not end within the grace period",
+				"grace_period", e.gracePeriod)
 			return
-		case <-ticker.C:
-			e.executeTarget(ctx, target)
 		}
 	}
 }
 
-// executeTarget executes nexttrace for a single target
-func (e *Executor) executeTarget(parentCtx context.Context, target config.Target) {
-	startTime := time.Now()
-	e.logger.Info("Starting nexttrace execution",
-		"target", target.Name,
-		"host", target.Host)
+// SchedulerStats returns the current scheduler queue depth and concurrency
+func (e *Executor) SchedulerStats() SchedulerStats {
+	return e.scheduler.stats()
+}
 
+// GetBackoffState returns how consecutive failures of a target currently
+// delay or pause its runs
+func (e *Executor) GetBackoffState(targetName string) (BackoffState, bool) {
+	return e.scheduler.backoffState(targetName)
+}
+
+// GetSchedulingLag returns how late the last run of a target started
+// compared to when it was due
+func (e *Executor) GetSchedulingLag(targetName string) (time.Duration, bool) {
+	return e.scheduler.lag(targetName)
+}
+
+// TriggerTarget runs a configured target now instead of waiting for its
+// next interval, without overlapping a run already in flight. It returns
+// the triggered run, or the one in flight, whose result is available once
+// it finishes. It fails with ErrUnknownTarget, a *RateLimitError or a
+// *BreakerOpenError.
+func (e *Executor) TriggerTarget(name string) (run *Run, running bool, err error) {
+	run, running, err = e.scheduler.trigger(name, e.triggerInterval)
+	if err != nil {
+		return nil, false, err
+	}
+
+	e.logger.Info("Target triggered manually", "target", name, "running", running)
+	return run, running, nil
+}
+
+// executeTarget executes nexttrace for a single target and stores the
+// result. It returns nil if the run was cancelled.
+func (e *Executor) executeTarget(parentCtx context.Context, target config.Target) *ExecutionResult {
 	// Create a context with timeout
 	ctx, cancel := context.WithTimeout(parentCtx, e.timeout)
 	defer cancel()
 
-	// Store cancel function
-	e.cancelFuncMutex.Lock()
-	e.cancelFuncs[target.Name] = cancel
-	e.cancelFuncMutex.Unlock()
+	e.events.publish(Event{
+		Type:    EventTraceStarted,
+		Target:  target.Name,
+		Host:    target.Host,
+		Backend: target.Backend,
+	})
+
+	streamed := false
+	result := e.run(ctx, target, func(hop parser.Hop) {
+		streamed = true
+		e.publishHop(target.Name, hop)
+	})
+
+	// Runs cut short by a stop or reload are not a property of the target
+	if parentCtx.Err() != nil {
+		e.logger.Debug("Discarding result of cancelled execution",
+			"target", target.Name,
+			"host", target.Host)
+		return nil
+	}
 
-	// Build command arguments with -j flag for JSON output, -C to disable ANSI color codes, and -M to disable map upload
-	args := []string{"-j", "-C", "-M", target.Host}
-	if target.MaxHops > 0 {
-		args = append(args, "--max-hops", fmt.Sprintf("%d", target.MaxHops))
+	// Backends that don't stream hops report them all at the end
+	if result.Result != nil && !streamed {
+		for _, hop := range result.Result.Hops {
+			e.publishHop(target.Name, hop)
+		}
 	}
 
-	// Execute command
-	cmd := exec.CommandContext(ctx, e.binaryPath, args...)
-	output, err := cmd.CombinedOutput()
+	// Store the result
+	e.storeResult(result)
+	return result
+}
+
+// publishHop enriches a copy of a hop of a running trace and publishes it.
+// The responders are copied too: the tracer's result shares them and is
+// enriched again once the trace is done, while subscribers read the event.
+func (e *Executor) publishHop(targetName string, hop parser.Hop) {
+	hop.Responders = append([]parser.Responder(nil), hop.Responders...)
+	r := parser.NextTraceResult{Hops: []parser.Hop{hop}}
+	e.enricher.Apply(&r)
+	e.events.publish(Event{Type: EventHopDiscovered, Target: targetName, Hop: &r.Hops[0]})
+}
+
+// RunTarget executes nexttrace once for a target and returns the result
+// without storing it. The trace is bounded by both the executor timeout and
+// the deadline of ctx, whichever comes first. It takes a worker from the
+// scheduler like scheduled targets do, and returns ErrNoWorker if none
+// frees up before the deadline.
+func (e *Executor) RunTarget(ctx context.Context, target config.Target) (*ExecutionResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	if err := e.scheduler.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer e.scheduler.release()
+
+	return e.run(ctx, target, nil), nil
+}
+
+// run executes nexttrace for a target under ctx and parses its output,
+// passing hops to onHop as they complete if the backend supports it
+func (e *Executor) run(ctx context.Context, target config.Target, onHop tracer.HopFunc) *ExecutionResult {
+	backend := target.Backend
+	if backend == "" {
+		backend = config.BackendNextTrace
+	}
+
+	e.inflight.Add(1)
+	defer e.inflight.Done()
+
+	// Stop also ends runs that weren't started by the scheduler
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(e.stopCtx, cancel)()
+
+	// Changed enrichment databases are picked up once per trace rather than
+	// for every hop
+	e.enricher.Refresh()
+
+	startTime := time.Now()
+	e.logger.Info("Starting nexttrace execution",
+		"target", target.Name,
+		"host", target.Host,
+		"backend", backend)
+
+	var (
+		parsed *parser.NextTraceResult
+		err    error
+	)
+	if t, exists := e.tracers[backend]; exists {
+		parsed, err = t.Trace(ctx, target, onHop)
+	} else {
+		err = fmt.Errorf("unknown backend %q", backend)
+	}
 	duration := time.Since(startTime)
 
 	result := &ExecutionResult{
@@ -119,52 +448,223 @@ func (e *Executor) executeTarget(parentCtx context.Context, target config.Target
 		Timestamp: time.Now(),
 	}
 
+	var (
+		parseErr       *tracer.ParseError
+		cmdErr         *tracer.CommandError
+		stdout, stderr []byte
+	)
+	if errors.As(err, &cmdErr) {
+		stdout, stderr = cmdErr.Stdout, cmdErr.Stderr
+	} else if errors.As(err, &parseErr) {
+		stdout, stderr = parseErr.Stdout, parseErr.Stderr
+	}
+	result.Stderr = stderrTail(stderr)
+
 	if ctx.Err() == context.DeadlineExceeded {
-		result.Status = "timeout"
-		result.Error = fmt.Errorf("execution timeout after %v", e.timeout)
+		result.Status = StatusTimeout
+		result.Reason = ReasonTimeout
+		result.Error = fmt.Errorf("execution timeout after %v", duration.Round(time.Second))
 		e.logger.Error("NextTrace execution timeout",
 			"target", target.Name,
 			"host", target.Host,
-			"duration", duration)
+			"backend", backend,
+			"duration", duration,
+			"stderr", result.Stderr)
+	} else if parseErr != nil {
+		result.Status = StatusParseError
+		result.Reason = ReasonParseError
+		result.Error = fmt.Errorf("failed to parse output: %w", parseErr.Err)
+		e.logger.Error("Failed to parse nexttrace output",
+			"target", target.Name,
+			"host", target.Host,
+			"backend", backend,
+			"error", parseErr.Err,
+			"stdout", string(stdout),
+			"stderr", result.Stderr)
 	} else if err != nil {
-		result.Status = "error"
+		result.Status = StatusError
+		// Tools print their error messages to either stream
+		result.Reason = classifyError(err, bytes.Join([][]byte{stderr, stdout}, []byte("\n")))
 		result.Error = fmt.Errorf("execution failed: %w", err)
 		e.logger.Error("NextTrace execution failed",
 			"target", target.Name,
 			"host", target.Host,
+			"backend", backend,
+			"reason", result.Reason,
 			"error", err,
-			"output", string(output))
+			"stderr", result.Stderr)
 	} else {
-		// Parse the output
-		parsed, parseErr := parser.ParseNextTraceOutput(output)
-		if parseErr != nil {
-			result.Status = "error"
-			result.Error = fmt.Errorf("failed to parse output: %w", parseErr)
-			e.logger.Error("Failed to parse nexttrace output",
-				"target", target.Name,
-				"host", target.Host,
-				"error", parseErr,
-				"output", string(output))
-		} else {
-			result.Status = "success"
-			result.Result = parsed
-			e.logger.Info("NextTrace execution completed successfully",
-				"target", target.Name,
-				"host", target.Host,
-				"duration", duration,
-				"hops", len(parsed.Hops))
+		e.complete(ctx, target, parsed)
+		result.Status = StatusSuccess
+		result.Result = parsed
+		e.logger.Info("NextTrace execution completed successfully",
+			"target", target.Name,
+			"host", target.Host,
+			"backend", backend,
+			"duration", duration,
+			"hops", len(parsed.Hops))
+	}
+
+	// Keep the hops completed before a timeout or failure; the reason still
+	// tells why the trace ended early
+	if err != nil && parsed != nil && len(parsed.Hops) > 0 {
+		e.complete(context.WithoutCancel(ctx), target, parsed)
+		result.Status = StatusPartial
+		result.Result = parsed
+		e.logger.Warn("Keeping partial trace result",
+			"target", target.Name,
+			"host", target.Host,
+			"backend", backend,
+			"reason", result.Reason,
+			"hops", len(parsed.Hops))
+	}
+
+	return result
+}
+
+// stderrTail returns the end of the standard error of a trace tool, at most
+// stderrTailSize bytes starting on a line boundary
+func stderrTail(stderr []byte) string {
+	if len(stderr) > stderrTailSize {
+		stderr = stderr[len(stderr)-stderrTailSize:]
+		if i := bytes.IndexByte(stderr, '\n'); i >= 0 && i < len(stderr)-1 {
+			stderr = stderr[i+1:]
 		}
 	}
+	return strings.ToValidUTF8(strings.TrimSpace(string(stderr)), "")
+}
 
-	// Store the result
+// complete adds the traced address and enrichment data to a parsed trace
+func (e *Executor) complete(ctx context.Context, target config.Target, parsed *parser.NextTraceResult) {
+	if parsed.TargetIP == "" {
+		e.resolveTargetIP(ctx, target, parsed)
+	}
+	e.enricher.Apply(parsed)
+}
+
+// resolveTargetIP sets the traced address of a result from backends that
+// don't report it, resolving the target host like nexttrace does. The
+// destination metrics are left out when this fails.
+func (e *Executor) resolveTargetIP(ctx context.Context, target config.Target, result *parser.NextTraceResult) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, target.Host)
+	if err != nil {
+		e.logger.Warn("Failed to resolve target address",
+			"target", target.Name,
+			"host", target.Host,
+			"error", err)
+		return
+	}
+
+	// nexttrace prefers IPv4, so try those addresses first
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return net.ParseIP(addrs[i]).To4() != nil && net.ParseIP(addrs[j]).To4() == nil
+	})
+	result.SetTargetIP(addrs)
+}
+
+// storeResult records a result as the latest for its target, appends it to
+// the target history, bumps the target's execution counter for the result
+// status and has the new state saved when storage is enabled
+func (e *Executor) storeResult(result *ExecutionResult) {
+	routeChange := e.recordResult(result)
+
+	e.events.publish(Event{Type: EventTraceFinished, Target: result.Target, Result: result})
+	if routeChange != nil {
+		e.events.publish(*routeChange)
+	}
+
+	e.requestSave()
+}
+
+// recordResult updates the in-memory state with a new result and returns
+// the route_changed event if the route differs from the previous one
+func (e *Executor) recordResult(result *ExecutionResult) *Event {
 	e.resultsMutex.Lock()
-	e.results[target.Name] = result
-	e.resultsMutex.Unlock()
+	defer e.resultsMutex.Unlock()
+
+	e.results[result.Target] = result
+
+	history := append(e.history[result.Target], result)
+	if len(history) > e.historySize {
+		history = history[len(history)-e.historySize:]
+	}
+	e.history[result.Target] = history
+
+	counts, exists := e.counts[result.Target]
+	if !exists {
+		counts = make(map[string]uint64, len(Statuses))
+		e.counts[result.Target] = counts
+	}
+	counts[result.Status]++
+
+	if result.Reason != "" {
+		reasonCounts, exists := e.reasonCounts[result.Target]
+		if !exists {
+			reasonCounts = make(map[string]uint64, len(Reasons))
+			e.reasonCounts[result.Target] = reasonCounts
+		}
+		reasonCounts[result.Reason]++
+	}
+
+	// Partial traces would show a route change at every hop they miss
+	if result.Status == StatusSuccess && result.Result != nil {
+		return e.trackRoute(result)
+	}
+	return nil
+}
 
-	// Clean up cancel function
-	e.cancelFuncMutex.Lock()
-	delete(e.cancelFuncs, target.Name)
-	e.cancelFuncMutex.Unlock()
+// trackRoute compares a successful result with the previous route for the
+// same target and records a route change when the fingerprint differs,
+// returning the matching event. Must be called with resultsMutex held.
+func (e *Executor) trackRoute(result *ExecutionResult) *Event {
+	route, exists := e.routes[result.Target]
+	if !exists {
+		e.routes[result.Target] = &RouteState{
+			Fingerprint: result.Result.Fingerprint(),
+			last:        result.Result,
+		}
+		return nil
+	}
+
+	// Hops that didn't answer this time are assumed unchanged
+	path := result.Result
+	if route.last != nil {
+		path = parser.MergeSilentHops(route.last, path)
+	}
+	fingerprint := path.Fingerprint()
+
+	var event *Event
+	if route.Fingerprint != fingerprint {
+		// The previous path is unknown when the route was restored from storage
+		// without a successful result in the history
+		var changedTTLs []int
+		if route.last != nil {
+			changedTTLs = parser.ChangedTTLs(route.last, path)
+		}
+		e.logger.Warn("Route change detected",
+			"target", result.Target,
+			"old_fingerprint", route.Fingerprint,
+			"new_fingerprint", fingerprint,
+			"changed_ttls", changedTTLs)
+
+		event = &Event{
+			Type:           EventRouteChanged,
+			Target:         result.Target,
+			Timestamp:      result.Timestamp,
+			OldFingerprint: route.Fingerprint,
+			NewFingerprint: fingerprint,
+			ChangedTTLs:    changedTTLs,
+		}
+
+		route.Fingerprint = fingerprint
+		route.Changes++
+		route.LastChange = result.Timestamp
+	}
+	route.last = path
+	return event
 }
 
 // GetResult returns the latest result for a target
@@ -189,27 +689,159 @@ func (e *Executor) GetAllResults() map[string]*ExecutionResult {
 	return results
 }
 
-// Reload updates the targets and restarts the execution loops
-func (e *Executor) Reload(ctx context.Context, targets []config.Target) {
-	e.logger.Info("Reloading executor with new targets", "count", len(targets))
+// GetHistory returns the most recent results for a target, oldest first
+func (e *Executor) GetHistory(targetName string) []*ExecutionResult {
+	e.resultsMutex.RLock()
+	defer e.resultsMutex.RUnlock()
+
+	history := make([]*ExecutionResult, len(e.history[targetName]))
+	copy(history, e.history[targetName])
+	return history
+}
+
+// GetExecutionCounts returns the cumulative number of executions per status
+// for a target. Counts are kept across reloads while the target stays configured.
+func (e *Executor) GetExecutionCounts(targetName string) map[string]uint64 {
+	e.resultsMutex.RLock()
+	defer e.resultsMutex.RUnlock()
 
-	// Stop all current executions
-	e.Stop()
+	counts := make(map[string]uint64, len(Statuses))
+	for status, count := range e.counts[targetName] {
+		counts[status] = count
+	}
+	return counts
+}
+
+// GetErrorCounts returns the cumulative number of failed executions per
+// error reason for a target, kept like the execution counts
+func (e *Executor) GetErrorCounts(targetName string) map[string]uint64 {
+	e.resultsMutex.RLock()
+	defer e.resultsMutex.RUnlock()
+
+	counts := make(map[string]uint64, len(Reasons))
+	for reason, count := range e.reasonCounts[targetName] {
+		counts[reason] = count
+	}
+	return counts
+}
+
+// GetRouteState returns the route tracking state for a target
+func (e *Executor) GetRouteState(targetName string) (RouteState, bool) {
+	e.resultsMutex.RLock()
+	defer e.resultsMutex.RUnlock()
+
+	route, exists := e.routes[targetName]
+	if !exists {
+		return RouteState{}, false
+	}
+	return *route, true
+}
+
+// Reload applies a new target list. Unchanged targets keep their schedule,
+// changed targets are rescheduled, removed targets are stopped and their
+// state dropped, and new targets are scheduled. Runs in flight of changed
+// and removed targets are cancelled, and waited for up to the grace period
+// before the new schedule starts.
+func (e *Executor) Reload(ctx context.Context, targets []config.Target) ReloadDiff {
+	e.reloadMutex.Lock()
+	defer e.reloadMutex.Unlock()
+
+	var (
+		diff     ReloadDiff
+		schedule []config.Target
+		stopping []<-chan struct{}
+	)
+	newTargetNames := make(map[string]bool, len(targets))
 
-	// Clear old results for targets that no longer exist
-	newTargetNames := make(map[string]bool)
 	for _, target := range targets {
 		newTargetNames[target.Name] = true
+
+		current, exists := e.scheduler.target(target.Name)
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, target.Name)
+			schedule = append(schedule, target)
+		case !reflect.DeepEqual(current, target):
+			diff.Changed = append(diff.Changed, target.Name)
+			stopping = append(stopping, e.scheduler.remove(target.Name))
+			schedule = append(schedule, target)
+		default:
+			diff.Unchanged = append(diff.Unchanged, target.Name)
+		}
 	}
 
+	for _, name := range e.scheduler.names() {
+		if !newTargetNames[name] {
+			diff.Removed = append(diff.Removed, name)
+			stopping = append(stopping, e.scheduler.remove(name))
+		}
+	}
+	sort.Strings(diff.Removed)
+
+	e.waitStopped(stopping)
+	for _, name := range diff.Changed {
+		e.resetTarget(name)
+	}
+	for _, target := range schedule {
+		e.scheduler.add(ctx, target)
+	}
+
+	// Clear old state for targets that no longer exist
+	e.pruneState(newTargetNames)
+
+	e.targets = targets
+
+	e.logger.Info("Reloaded executor targets",
+		"count", len(targets),
+		"added", diff.Added,
+		"removed", diff.Removed,
+		"changed", diff.Changed,
+		"unchanged", len(diff.Unchanged))
+
+	return diff
+}
+
+// pruneState drops results, history, counters and routes of every target not
+// in names
+func (e *Executor) pruneState(names map[string]bool) {
 	e.resultsMutex.Lock()
 	for name := range e.results {
-		if !newTargetNames[name] {
+		if !names[name] {
 			delete(e.results, name)
 		}
 	}
+	for name := range e.history {
+		if !names[name] {
+			delete(e.history, name)
+		}
+	}
+	for name := range e.counts {
+		if !names[name] {
+			delete(e.counts, name)
+		}
+	}
+	for name := range e.reasonCounts {
+		if !names[name] {
+			delete(e.reasonCounts, name)
+		}
+	}
+	for name := range e.routes {
+		if !names[name] {
+			delete(e.routes, name)
+		}
+	}
 	e.resultsMutex.Unlock()
 
-	// Start new executions
-	e.Start(ctx, targets)
+	e.requestSave()
+}
+
+// resetTarget drops the latest result and route of a target whose settings
+// changed, so the new settings are not compared against the old path.
+// Execution counters are kept.
+func (e *Executor) resetTarget(name string) {
+	e.resultsMutex.Lock()
+	defer e.resultsMutex.Unlock()
+
+	delete(e.results, name)
+	delete(e.routes, name)
 }
@@ -0,0 +1,464 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+	"github.com/vinsec/nexttrace_exporter/tracer"
+)
+
+func TestExecutionCounts(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	e.SetTestResult("a", &parser.NextTraceResult{}, time.Second)
+	e.SetTestResult("a", &parser.NextTraceResult{}, time.Second)
+	e.storeResult(&ExecutionResult{Target: "a", Status: StatusTimeout, Reason: ReasonTimeout})
+	e.storeResult(&ExecutionResult{Target: "b", Status: StatusParseError, Reason: ReasonParseError})
+
+	counts := e.GetExecutionCounts("a")
+	if counts[StatusSuccess] != 2 {
+		t.Errorf("Expected 2 successful executions, got %d", counts[StatusSuccess])
+	}
+	if counts[StatusTimeout] != 1 {
+		t.Errorf("Expected 1 timeout, got %d", counts[StatusTimeout])
+	}
+	if counts[StatusError] != 0 {
+		t.Errorf("Expected 0 errors, got %d", counts[StatusError])
+	}
+	if reasons := e.GetErrorCounts("a"); reasons[ReasonTimeout] != 1 || len(reasons) != 1 {
+		t.Errorf("Expected 1 timeout error reason, got %v", reasons)
+	}
+
+	// Counts for removed targets are dropped on reload
+	e.Reload(context.Background(), nil)
+	if counts := e.GetExecutionCounts("b"); len(counts) != 0 {
+		t.Errorf("Expected counts for removed target to be cleared, got %v", counts)
+	}
+	if reasons := e.GetErrorCounts("b"); len(reasons) != 0 {
+		t.Errorf("Expected error counts for removed target to be cleared, got %v", reasons)
+	}
+}
+
+func TestRouteTracking(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	routeA := &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "10.0.0.1"}, {TTL: 2, IP: "10.0.1.1"}}}
+	routeB := &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "10.0.0.1"}, {TTL: 2, IP: "10.0.2.1"}}}
+
+	e.SetTestResult("a", routeA, time.Second)
+	route, exists := e.GetRouteState("a")
+	if !exists {
+		t.Fatal("Expected route state after first result")
+	}
+	if route.Changes != 0 || !route.LastChange.IsZero() {
+		t.Errorf("Expected no route change after first result, got %+v", route)
+	}
+
+	// Failed executions do not affect the route
+	e.storeResult(&ExecutionResult{Target: "a", Status: StatusError})
+	e.SetTestResult("a", routeA, time.Second)
+	if route, _ := e.GetRouteState("a"); route.Changes != 0 {
+		t.Errorf("Expected no route change for identical route, got %d", route.Changes)
+	}
+
+	e.SetTestResult("a", routeB, time.Second)
+	route, _ = e.GetRouteState("a")
+	if route.Changes != 1 {
+		t.Errorf("Expected 1 route change, got %d", route.Changes)
+	}
+	if route.Fingerprint != routeB.Fingerprint() {
+		t.Errorf("Expected fingerprint %s, got %s", routeB.Fingerprint(), route.Fingerprint)
+	}
+	if route.LastChange.IsZero() {
+		t.Error("Expected last change timestamp to be set")
+	}
+
+	// A hop that doesn't answer is assumed unchanged
+	silent := &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "*"}, {TTL: 2, IP: "10.0.2.1"}}}
+	e.SetTestResult("a", silent, time.Second)
+	e.SetTestResult("a", routeB, time.Second)
+	if route, _ := e.GetRouteState("a"); route.Changes != 1 || route.Fingerprint != routeB.Fingerprint() {
+		t.Errorf("Expected silent hops not to change the route, got %d changes", route.Changes)
+	}
+}
+
+func TestReloadDiff(t *testing.T) {
+	e := NewExecutor("/nonexistent/nexttrace", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer e.Stop()
+
+	keep := config.Target{Name: "keep", Host: "8.8.8.8", Interval: time.Hour, MaxHops: 30, Protocol: config.ProtocolICMP}
+	change := config.Target{Name: "change", Host: "1.1.1.1", Interval: time.Hour, MaxHops: 30, Protocol: config.ProtocolICMP}
+	remove := config.Target{Name: "remove", Host: "9.9.9.9", Interval: time.Hour, MaxHops: 30, Protocol: config.ProtocolICMP}
+	e.Start(ctx, []config.Target{keep, change, remove})
+
+	e.scheduler.mu.Lock()
+	keepEntry := e.scheduler.entries["keep"]
+	e.scheduler.mu.Unlock()
+
+	changed := change
+	changed.Protocol = config.ProtocolTCP
+	changed.Port = 443
+	add := config.Target{Name: "add", Host: "208.67.222.222", Interval: time.Hour, MaxHops: 30, Protocol: config.ProtocolICMP}
+
+	diff := e.Reload(ctx, []config.Target{keep, changed, add})
+
+	expected := ReloadDiff{
+		Added:     []string{"add"},
+		Removed:   []string{"remove"},
+		Changed:   []string{"change"},
+		Unchanged: []string{"keep"},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Reload() = %+v, want %+v", diff, expected)
+	}
+
+	e.scheduler.mu.Lock()
+	defer e.scheduler.mu.Unlock()
+	if e.scheduler.entries["keep"] != keepEntry {
+		t.Error("Expected unchanged target to keep its schedule")
+	}
+	if _, exists := e.scheduler.entries["remove"]; exists {
+		t.Error("Expected removed target to be unscheduled")
+	}
+	if e.scheduler.entries["change"].target.Protocol != config.ProtocolTCP {
+		t.Error("Expected changed target to be scheduled with the new settings")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	e.SetHistorySize(3)
+
+	for i := 0; i < 5; i++ {
+		e.storeResult(&ExecutionResult{Target: "a", Status: StatusError, Duration: time.Duration(i) * time.Second})
+	}
+
+	history := e.GetHistory("a")
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 results in history, got %d", len(history))
+	}
+	if history[0].Duration != 2*time.Second || history[2].Duration != 4*time.Second {
+		t.Errorf("Expected the 3 most recent results oldest first, got %v, %v", history[0].Duration, history[2].Duration)
+	}
+}
+
+// fakeTracer returns a fixed result or error, optionally streaming the hops
+// of the result first and waiting for ctx to end
+type fakeTracer struct {
+	result *parser.NextTraceResult
+	err    error
+	stream bool
+	hang   bool
+}
+
+func (f *fakeTracer) Trace(ctx context.Context, target config.Target, onHop tracer.HopFunc) (*parser.NextTraceResult, error) {
+	if f.stream && f.result != nil {
+		for _, hop := range f.result.Hops {
+			onHop(hop)
+		}
+	}
+	if f.hang {
+		<-ctx.Done()
+	}
+	return f.result, f.err
+}
+
+func TestRunBackend(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name     string
+		tracer   tracer.Tracer
+		expected string
+		reason   string
+		stderr   string
+	}{
+		{
+			name:     "success",
+			tracer:   &fakeTracer{result: &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1}}}},
+			expected: StatusSuccess,
+		},
+		{
+			name:     "command error",
+			tracer:   &fakeTracer{err: &tracer.CommandError{Err: errors.New("exit status 1")}},
+			expected: StatusError,
+			reason:   ReasonUnknown,
+		},
+		{
+			name:     "command error with output",
+			tracer:   &fakeTracer{err: &tracer.CommandError{Err: errors.New("exit status 1"), Stderr: []byte("socket: Operation not permitted\n")}},
+			expected: StatusError,
+			reason:   ReasonPermissionDenied,
+			stderr:   "socket: Operation not permitted",
+		},
+		{
+			name:     "command error with message on stdout",
+			tracer:   &fakeTracer{err: &tracer.CommandError{Err: errors.New("exit status 1"), Stdout: []byte("traceroute: unknown host example.invalid\n")}},
+			expected: StatusError,
+			reason:   ReasonDNSFailure,
+		},
+		{
+			name:     "parse error",
+			tracer:   &fakeTracer{err: &tracer.ParseError{Err: errors.New("unexpected end of JSON input")}},
+			expected: StatusParseError,
+			reason:   ReasonParseError,
+		},
+		{
+			name: "command error after some hops",
+			tracer: &fakeTracer{
+				result: &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1}}},
+				err:    &tracer.CommandError{Err: errors.New("exit status 1"), Stderr: []byte("connect: network is unreachable\n")},
+			},
+			expected: StatusPartial,
+			reason:   ReasonNetworkUnreachable,
+			stderr:   "connect: network is unreachable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e.tracers["fake"] = tt.tracer
+			result, _ := e.RunTarget(context.Background(), config.Target{Name: "a", Host: "8.8.8.8", Backend: "fake"})
+			if result.Status != tt.expected {
+				t.Errorf("Expected status %s, got %s (%v)", tt.expected, result.Status, result.Error)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("Expected reason %q, got %q", tt.reason, result.Reason)
+			}
+			if result.Stderr != tt.stderr {
+				t.Errorf("Expected stderr %q, got %q", tt.stderr, result.Stderr)
+			}
+		})
+	}
+
+	// Unknown backends fail instead of silently falling back
+	result, _ := e.RunTarget(context.Background(), config.Target{Name: "a", Host: "8.8.8.8", Backend: "missing"})
+	if result.Status != StatusError {
+		t.Errorf("Expected status error for unknown backend, got %s", result.Status)
+	}
+}
+
+func TestRunTargetWorkers(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	e.tracers["fake"] = &fakeTracer{result: &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "8.8.8.8"}}}}
+	e.Configure(config.SchedulerConfig{MaxConcurrentTraces: 1})
+	target := config.Target{Name: "probe", Host: "8.8.8.8", Backend: "fake"}
+
+	// The only worker is busy with a scheduled target
+	if err := e.scheduler.acquire(context.Background()); err != nil {
+		t.Fatalf("Failed to take the worker: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := e.RunTarget(ctx, target); !errors.Is(err, ErrNoWorker) {
+		t.Errorf("Expected ErrNoWorker while the worker is busy, got %v", err)
+	}
+
+	// A probe waits for the worker to free up
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		e.scheduler.release()
+	}()
+	result, err := e.RunTarget(context.Background(), target)
+	if err != nil {
+		t.Fatalf("Expected the probe to run once the worker is free, got %v", err)
+	}
+	if result.Status != StatusSuccess {
+		t.Errorf("Expected status success, got %s", result.Status)
+	}
+	if stats := e.SchedulerStats(); stats.Running != 0 {
+		t.Errorf("Expected the probe to release its worker, %d running", stats.Running)
+	}
+}
+
+func TestStderrTail(t *testing.T) {
+	if tail := stderrTail([]byte("warning\nfatal error\n")); tail != "warning\nfatal error" {
+		t.Errorf("Expected short stderr to be kept, got %q", tail)
+	}
+
+	long := bytes.Repeat([]byte("warning: slow response\n"), 1000)
+	long = append(long, "fatal error\n"...)
+	tail := stderrTail(long)
+	if len(tail) > stderrTailSize || !strings.HasPrefix(tail, "warning") || !strings.HasSuffix(tail, "fatal error") {
+		t.Errorf("Expected the last lines within %d bytes, got %d bytes: %q", stderrTailSize, len(tail), tail[:40])
+	}
+}
+
+func TestRunResolvesTargetIP(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Backends that don't report the traced address get it from the host
+	e.tracers["fake"] = &fakeTracer{result: &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1}}}}
+	result, _ := e.RunTarget(context.Background(), config.Target{Name: "a", Host: "8.8.8.8", Backend: "fake"})
+	if result.Result == nil || result.Result.TargetIP != "8.8.8.8" {
+		t.Fatalf("Expected target IP 8.8.8.8, got %+v", result.Result)
+	}
+
+	// An address reported by the backend is kept
+	e.tracers["fake"] = &fakeTracer{result: &parser.NextTraceResult{TargetIP: "8.8.4.4", Hops: []parser.Hop{{TTL: 1}}}}
+	result, _ = e.RunTarget(context.Background(), config.Target{Name: "a", Host: "8.8.8.8", Backend: "fake"})
+	if result.Result.TargetIP != "8.8.4.4" {
+		t.Errorf("Expected reported target IP 8.8.4.4, got %s", result.Result.TargetIP)
+	}
+}
+
+func TestExecuteTargetPartial(t *testing.T) {
+	e := NewExecutor("nexttrace", 50*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	e.tracers["fake"] = &fakeTracer{
+		result: &parser.NextTraceResult{
+			TargetIP: "8.8.8.8",
+			Hops:     []parser.Hop{{TTL: 1, IP: "192.168.1.1"}, {TTL: 2, IP: "10.0.0.1"}},
+		},
+		err:    &tracer.CommandError{Err: errors.New("signal: killed")},
+		stream: true,
+		hang:   true,
+	}
+
+	sub := e.Subscribe(0, "a")
+	defer sub.Close()
+
+	e.executeTarget(context.Background(), config.Target{Name: "a", Host: "8.8.8.8", Backend: "fake"})
+
+	result, exists := e.GetResult("a")
+	if !exists || result.Status != StatusPartial || result.Reason != ReasonTimeout {
+		t.Fatalf("Expected partial result after timeout, got %+v", result)
+	}
+	if result.Result == nil || len(result.Result.Hops) != 2 {
+		t.Errorf("Expected the 2 completed hops to be kept, got %+v", result.Result)
+	}
+
+	// Streamed hops are published once, and partial routes aren't tracked
+	var hops int
+	for _, event := range drain(sub) {
+		if event.Type == EventHopDiscovered {
+			hops++
+		}
+	}
+	if hops != 2 {
+		t.Errorf("Expected 2 hop events, got %d", hops)
+	}
+	if _, exists := e.GetRouteState("a"); exists {
+		t.Error("Expected no route state from a partial trace")
+	}
+}
+
+// slowTracer blocks until ctx is done and then takes delay to return, like
+// a tool that is slow to exit after being killed
+type slowTracer struct {
+	started chan string
+	delay   time.Duration
+	ended   atomic.Int32
+}
+
+func (s *slowTracer) Trace(ctx context.Context, target config.Target, onHop tracer.HopFunc) (*parser.NextTraceResult, error) {
+	s.started <- target.Name
+	<-ctx.Done()
+	time.Sleep(s.delay)
+	s.ended.Add(1)
+	return nil, ctx.Err()
+}
+
+func TestStopWaitsForRuns(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	slow := &slowTracer{started: make(chan string, 2), delay: 50 * time.Millisecond}
+	e.tracers["fake"] = slow
+	e.SetGracePeriod(5 * time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Start(ctx, []config.Target{{Name: "a", Host: "8.8.8.8", Backend: "fake", Interval: time.Hour}})
+
+	// Probes aren't scheduled, but are ended and waited for as well
+	go e.RunTarget(context.Background(), config.Target{Name: "probe", Host: "8.8.8.8", Backend: "fake"})
+	<-slow.started
+	<-slow.started
+
+	e.Stop()
+	if ended := slow.ended.Load(); ended != 2 {
+		t.Errorf("Expected Stop to wait for 2 runs, %d ended", ended)
+	}
+}
+
+func TestTriggerCancelledRun(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	slow := &slowTracer{started: make(chan string, 1)}
+	e.tracers["fake"] = slow
+	e.SetTestResult("a", &parser.NextTraceResult{}, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Start(ctx, []config.Target{{Name: "a", Host: "8.8.8.8", Backend: "fake", Interval: time.Hour}})
+	<-slow.started
+
+	run, running, err := e.TriggerTarget("a")
+	if err != nil || !running {
+		t.Fatalf("Expected trigger to join the run in flight, got running=%v err=%v", running, err)
+	}
+
+	// The previous result is not the result of the cancelled run
+	e.Stop()
+	waitClosed(t, run.Done())
+	if result := run.Result(); result != nil {
+		t.Errorf("Expected no result for the cancelled run, got %+v", result)
+	}
+}
+
+func TestStopGracePeriod(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	slow := &slowTracer{started: make(chan string, 1), delay: time.Second}
+	e.tracers["fake"] = slow
+	e.SetGracePeriod(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Start(ctx, []config.Target{{Name: "a", Host: "8.8.8.8", Backend: "fake", Interval: time.Hour}})
+	<-slow.started
+
+	start := time.Now()
+	e.Stop()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected Stop to give up after the grace period, took %v", elapsed)
+	}
+	if slow.ended.Load() != 0 {
+		t.Error("Expected the run to still be ending")
+	}
+}
+
+func TestReloadWaitsForCancelledRuns(t *testing.T) {
+	e := NewExecutor("nexttrace", time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	slow := &slowTracer{started: make(chan string, 2), delay: 50 * time.Millisecond}
+	e.tracers["fake"] = slow
+	e.SetGracePeriod(5 * time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target := config.Target{Name: "a", Host: "8.8.8.8", Backend: "fake", Interval: time.Hour}
+	e.Start(ctx, []config.Target{target})
+	<-slow.started
+
+	// The run with the old settings ends before the new one starts
+	target.Host = "8.8.4.4"
+	e.Reload(ctx, []config.Target{target})
+	if slow.ended.Load() != 1 {
+		t.Error("Expected Reload to wait for the cancelled run")
+	}
+	<-slow.started
+
+	e.Stop()
+	if ended := slow.ended.Load(); ended != 2 {
+		t.Errorf("Expected both runs to have ended, %d did", ended)
+	}
+}
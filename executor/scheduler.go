@@ -0,0 +1,533 @@
+package executor
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+// ErrUnknownTarget is returned when triggering a target that is not scheduled
+var ErrUnknownTarget = errors.New("unknown target")
+
+// ErrNoWorker is returned when no worker frees up for an unscheduled run
+// before its deadline
+var ErrNoWorker = errors.New("no free worker")
+
+// RateLimitError is returned when a target is triggered again too soon
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("target was triggered recently, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// BreakerOpenError is returned when triggering a target that is paused by
+// its circuit breaker
+type BreakerOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("target is paused by its circuit breaker, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// SchedulerStats describes the state of the scheduler queue
+type SchedulerStats struct {
+	QueueDepth    int // Targets that are due but waiting for a free worker
+	Running       int // Traces currently executing
+	MaxConcurrent int
+}
+
+// Run is a scheduled run of a target, as returned by TriggerTarget
+type Run struct {
+	done   chan struct{}
+	result *ExecutionResult
+}
+
+// newRun creates a run that hasn't finished yet
+func newRun() *Run {
+	return &Run{done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed when the run finishes or is
+// cancelled
+func (r *Run) Done() <-chan struct{} {
+	return r.done
+}
+
+// Result returns the result of the run once Done is closed, or nil if the
+// run was cancelled by a stop or reload
+func (r *Run) Result() *ExecutionResult {
+	select {
+	case <-r.done:
+		return r.result
+	default:
+		return nil
+	}
+}
+
+// scheduledTarget is a target tracked by the scheduler
+type scheduledTarget struct {
+	target  config.Target
+	ctx     context.Context
+	cancel  context.CancelFunc
+	base    time.Time     // Next run on the regular interval grid, before jitter
+	next    time.Time     // Next run including jitter
+	lag     time.Duration // How late the last run started
+	removed bool
+	index   int // Position in the queue, -1 while running
+	// run is the current run, or the next one while queued. It finishes
+	// when the target is removed.
+	run         *Run
+	triggered   bool // Whether the next run was triggered ahead of the interval grid
+	lastTrigger time.Time
+	failures    failureTracker
+}
+
+// dueQueue is a min-heap of scheduled targets ordered by next run time
+type dueQueue []*scheduledTarget
+
+func (q dueQueue) Len() int           { return len(q) }
+func (q dueQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q dueQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *dueQueue) Push(x any) {
+	entry := x.(*scheduledTarget)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *dueQueue) Pop() any {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*q = old[:n-1]
+	return entry
+}
+
+// scheduler runs targets on their interval through a bounded pool of
+// concurrent executions, always starting the target that is due first.
+// Targets that keep failing are delayed by the backoff and paused by the
+// circuit breaker.
+type scheduler struct {
+	mu            sync.Mutex
+	queue         dueQueue
+	entries       map[string]*scheduledTarget
+	running       int
+	waiting       int           // Unscheduled runs waiting for a worker
+	freed         chan struct{} // Closed and replaced when a worker frees up
+	maxConcurrent int
+	splay         time.Duration
+	jitter        float64
+	backoff       config.BackoffConfig
+	breaker       config.CircuitBreakerConfig
+	started       bool
+	wake          chan struct{}
+	rand          *rand.Rand
+	execute       executeFunc
+	logger        *slog.Logger
+}
+
+// executeFunc runs a target and returns the result, or nil if the run was
+// cancelled
+type executeFunc func(ctx context.Context, target config.Target) *ExecutionResult
+
+// newScheduler creates a scheduler that runs targets with execute
+func newScheduler(execute executeFunc, logger *slog.Logger) *scheduler {
+	return &scheduler{
+		entries:       make(map[string]*scheduledTarget),
+		maxConcurrent: config.DefaultMaxConcurrentTraces,
+		freed:         make(chan struct{}),
+		wake:          make(chan struct{}, 1),
+		rand:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		execute:       execute,
+		logger:        logger,
+	}
+}
+
+// configure updates the concurrency limit, start time spreading, backoff
+// and circuit breaker. It applies to targets added and rescheduled from now
+// on.
+func (s *scheduler) configure(cfg config.SchedulerConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cfg.MaxConcurrentTraces > 0 {
+		s.maxConcurrent = cfg.MaxConcurrentTraces
+	}
+	s.splay = cfg.Splay
+	s.jitter = cfg.Jitter
+	s.backoff = cfg.Backoff
+	s.breaker = cfg.CircuitBreaker
+	s.notify()
+}
+
+// start launches the dispatcher once; it runs until ctx is done
+func (s *scheduler) start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+	go s.dispatch(ctx)
+}
+
+// add schedules a target, spreading its first run over the splay window
+func (s *scheduler) add(ctx context.Context, target config.Target) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entryCtx, cancel := context.WithCancel(ctx)
+	entry := &scheduledTarget{
+		target: target,
+		ctx:    entryCtx,
+		cancel: cancel,
+		run:    newRun(),
+	}
+	entry.failures.Breaker = BreakerClosed
+
+	splay := s.splay
+	if splay > target.Interval {
+		splay = target.Interval
+	}
+	entry.base = time.Now().Add(s.randomDuration(splay))
+	entry.next = entry.base
+
+	s.entries[target.Name] = entry
+	heap.Push(&s.queue, entry)
+	s.notify()
+}
+
+// remove unschedules a target and cancels its run in flight, if any. The
+// returned channel is closed once that run has returned.
+func (s *scheduler) remove(name string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.entries[name]
+	if !exists {
+		return nil
+	}
+
+	entry.removed = true
+	entry.cancel()
+	if entry.index >= 0 {
+		// A run in flight finishes when it returns
+		heap.Remove(&s.queue, entry.index)
+		close(entry.run.done)
+	}
+	delete(s.entries, name)
+	return entry.run.done
+}
+
+// trigger moves a queued target to the front of the queue so it runs as
+// soon as a worker is free. A target that is already running is not started
+// again; the run in flight is returned instead.
+// Triggers closer than minInterval apart are rejected with a RateLimitError,
+// and triggers of a target paused by its circuit breaker with a
+// BreakerOpenError until the cooldown ends.
+func (s *scheduler) trigger(name string, minInterval time.Duration) (run *Run, running bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.entries[name]
+	if !exists {
+		return nil, false, ErrUnknownTarget
+	}
+
+	now := time.Now()
+	if entry.index >= 0 && entry.failures.Breaker == BreakerOpen {
+		if wait := entry.next.Sub(now); wait > 0 {
+			return nil, false, &BreakerOpenError{RetryAfter: wait}
+		}
+	}
+	if !entry.lastTrigger.IsZero() {
+		if wait := entry.lastTrigger.Add(minInterval).Sub(now); wait > 0 {
+			return nil, false, &RateLimitError{RetryAfter: wait}
+		}
+	}
+	entry.lastTrigger = now
+
+	if entry.index < 0 {
+		return entry.run, true, nil
+	}
+
+	if entry.next.After(now) {
+		// Runs ahead of the grid don't move it, see runEntry
+		entry.triggered = entry.base.After(now)
+		entry.next = now
+		heap.Fix(&s.queue, entry.index)
+		s.notify()
+	}
+	return entry.run, false, nil
+}
+
+// target returns the settings a target is currently scheduled with
+func (s *scheduler) target(name string) (config.Target, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.entries[name]
+	if !exists {
+		return config.Target{}, false
+	}
+	return entry.target, true
+}
+
+// names returns the names of all scheduled targets
+func (s *scheduler) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	return names
+}
+
+// lag returns how late the last run of a target started
+func (s *scheduler) lag(name string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.entries[name]
+	if !exists {
+		return 0, false
+	}
+	return entry.lag, true
+}
+
+// backoffState returns how the failures of a target affect its schedule
+func (s *scheduler) backoffState(name string) (BackoffState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.entries[name]
+	if !exists {
+		return BackoffState{}, false
+	}
+	return entry.failures.BackoffState, true
+}
+
+// stats returns the current queue depth and concurrency
+func (s *scheduler) stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	stats := SchedulerStats{
+		Running:       s.running,
+		MaxConcurrent: s.maxConcurrent,
+	}
+	for _, entry := range s.queue {
+		if !entry.next.After(now) {
+			stats.QueueDepth++
+		}
+	}
+	return stats
+}
+
+// dispatch starts due targets while workers are free and sleeps until the
+// next target is due or the queue changes
+func (s *scheduler) dispatch(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		wait := s.startDue()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// startDue starts every due target that fits in the worker pool and returns
+// how long to wait before the next target is due
+func (s *scheduler) startDue() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Unscheduled runs waiting for a worker go first
+	for s.queue.Len() > 0 && s.running+s.waiting < s.maxConcurrent {
+		now := time.Now()
+		head := s.queue[0]
+		if head.next.After(now) {
+			return head.next.Sub(now)
+		}
+
+		heap.Pop(&s.queue)
+		head.lag = now.Sub(head.next)
+		if head.failures.Breaker == BreakerOpen {
+			head.failures.Breaker = BreakerHalfOpen
+			s.logger.Info("Circuit breaker half-open, retrying target",
+				"target", head.target.Name)
+		}
+		s.running++
+		go s.runEntry(head)
+	}
+
+	// Either the queue is empty or all workers are busy; a finishing run
+	// or a queue change will wake the dispatcher
+	return time.Hour
+}
+
+// runEntry executes a scheduled target and puts it back in the queue
+func (s *scheduler) runEntry(entry *scheduledTarget) {
+	result := s.execute(entry.ctx, entry.target)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.releaseLocked()
+	entry.run.result = result
+	close(entry.run.done)
+
+	if !entry.removed {
+		entry.run = newRun()
+
+		// Stay on the interval grid, skipping runs that were missed. A
+		// triggered run keeps the regular run it was started ahead of.
+		now := time.Now()
+		if entry.triggered {
+			entry.triggered = false
+		} else {
+			entry.base = entry.base.Add(entry.target.Interval)
+		}
+		if entry.base.Before(now) {
+			entry.base = now
+		}
+		entry.next = entry.base.Add(s.randomDuration(time.Duration(s.jitter * float64(entry.target.Interval))))
+
+		if result != nil {
+			s.recordResult(entry, result)
+		}
+		entry.next = entry.next.Add(entry.failures.Backoff)
+		if entry.failures.Breaker == BreakerOpen {
+			if resume := now.Add(s.breaker.Cooldown); resume.After(entry.next) {
+				entry.next = resume
+			}
+		}
+		heap.Push(&s.queue, entry)
+	}
+	s.notify()
+}
+
+// acquire takes a worker for a run outside of the schedule, such as a
+// probe, waiting until one is free or ctx is done. Waiting runs get the next
+// free worker before due targets do.
+func (s *scheduler) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.running >= s.maxConcurrent {
+		freed := s.freed
+		s.waiting++
+		s.mu.Unlock()
+
+		var err error
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		s.mu.Lock()
+		s.waiting--
+		if err != nil {
+			// The slot held back for this run goes to due targets
+			s.notify()
+			return fmt.Errorf("%w: %w", ErrNoWorker, err)
+		}
+	}
+	s.running++
+	return nil
+}
+
+// release returns a worker taken with acquire
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+	s.notify()
+}
+
+// releaseLocked frees a worker and wakes the runs waiting for one.
+// Must be called with mu held.
+func (s *scheduler) releaseLocked() {
+	s.running--
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
+
+// recordResult updates the failure state of a target with the result of a
+// run and logs circuit breaker changes.
+// Must be called with mu held.
+func (s *scheduler) recordResult(entry *scheduledTarget, result *ExecutionResult) {
+	previous := entry.failures.Breaker
+	state := entry.failures.record(result, s.backoff, s.breaker)
+
+	switch {
+	case state == BreakerOpen && previous != BreakerOpen:
+		s.logger.Warn("Circuit breaker opened, pausing target",
+			"target", entry.target.Name,
+			"reason", result.Reason,
+			"failures", entry.failures.Failures,
+			"cooldown", s.breaker.Cooldown)
+	case state == BreakerClosed && previous != BreakerClosed:
+		s.logger.Info("Circuit breaker closed, resuming target",
+			"target", entry.target.Name)
+	case entry.failures.Backoff > 0:
+		s.logger.Debug("Delaying next execution of failing target",
+			"target", entry.target.Name,
+			"failures", entry.failures.Failures,
+			"backoff", entry.failures.Backoff)
+	}
+}
+
+// randomDuration returns a random duration in [0, max).
+// Must be called with mu held.
+func (s *scheduler) randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(s.rand.Int63n(int64(max)))
+}
+
+// notify wakes the dispatcher without blocking.
+// Must be called with mu held.
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,289 @@
+package executor
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+func TestSchedulerConcurrencyLimit(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		active  int
+		peak    int
+		runs    int
+		release = make(chan struct{})
+	)
+
+	s := newScheduler(func(ctx context.Context, target config.Target) *ExecutionResult {
+		mu.Lock()
+		active++
+		runs++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.configure(config.SchedulerConfig{MaxConcurrentTraces: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s.add(ctx, config.Target{Name: name, Host: name, Interval: time.Hour})
+	}
+	s.start(ctx)
+
+	// Wait for the pool to fill up
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if stats := s.stats(); stats.Running == 2 && stats.QueueDepth == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 2 running and 3 queued, got %+v", s.stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(release)
+
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		done := runs == 5
+		mu.Unlock()
+		if done && s.stats().Running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected all 5 targets to run, got %d", runs)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent runs, got %d", peak)
+	}
+
+	// Every target is back in the queue, due an interval later
+	if stats := s.stats(); stats.QueueDepth != 0 || s.queue.Len() != 5 {
+		t.Errorf("Expected 5 targets scheduled for later, got %+v with %d queued", stats, s.queue.Len())
+	}
+}
+
+func TestSchedulerSplay(t *testing.T) {
+	s := newScheduler(func(ctx context.Context, target config.Target) *ExecutionResult { return nil }, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.configure(config.SchedulerConfig{MaxConcurrentTraces: 1, Splay: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	for _, name := range []string{"a", "b", "c"} {
+		s.add(ctx, config.Target{Name: name, Host: name, Interval: time.Hour})
+	}
+
+	for _, entry := range s.queue {
+		if entry.next.Before(start) || entry.next.After(start.Add(time.Minute+time.Second)) {
+			t.Errorf("Expected first run of %s within the splay window, got %v", entry.target.Name, entry.next.Sub(start))
+		}
+	}
+
+	s.remove("b")
+	if _, exists := s.target("b"); exists || s.queue.Len() != 2 {
+		t.Errorf("Expected b to be removed from the queue")
+	}
+}
+
+func TestSchedulerTrigger(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		runs    int
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+
+	s := newScheduler(func(ctx context.Context, target config.Target) *ExecutionResult {
+		mu.Lock()
+		runs++
+		mu.Unlock()
+		started <- struct{}{}
+		<-release
+		return &ExecutionResult{Target: target.Name, Status: StatusSuccess}
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.add(ctx, config.Target{Name: "a", Host: "a", Interval: time.Hour})
+	s.start(ctx)
+	<-started
+
+	// The first run is in flight: no second run is started
+	run, running, err := s.trigger("a", 0)
+	if err != nil || !running {
+		t.Fatalf("Expected trigger to join the run in flight, got running=%v err=%v", running, err)
+	}
+	if run.Result() != nil {
+		t.Errorf("Expected no result before the run finishes")
+	}
+	release <- struct{}{}
+	waitClosed(t, run.Done())
+	if result := run.Result(); result == nil || result.Status != StatusSuccess {
+		t.Errorf("Expected the result of the run, got %+v", result)
+	}
+
+	s.mu.Lock()
+	base := s.entries["a"].base
+	s.mu.Unlock()
+
+	// Queued for an hour from now: the trigger starts it right away
+	run, running, err = s.trigger("a", 0)
+	if err != nil || running {
+		t.Fatalf("Expected trigger to queue a run, got running=%v err=%v", running, err)
+	}
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected triggered run to start")
+	}
+	release <- struct{}{}
+	waitClosed(t, run.Done())
+	if run.Result() == nil {
+		t.Errorf("Expected the result of the triggered run")
+	}
+
+	s.mu.Lock()
+	if entry := s.entries["a"]; !entry.base.Equal(base) {
+		t.Errorf("Expected triggered run to keep the interval grid at %v, got %v", base, entry.base)
+	}
+	s.mu.Unlock()
+
+	mu.Lock()
+	if runs != 2 {
+		t.Errorf("Expected 2 runs, got %d", runs)
+	}
+	mu.Unlock()
+
+	// Rate limiting
+	var rateErr *RateLimitError
+	if _, _, err := s.trigger("a", time.Hour); !errors.As(err, &rateErr) || rateErr.RetryAfter <= 0 {
+		t.Errorf("Expected rate limit error, got %v", err)
+	}
+	if _, _, err := s.trigger("unknown", 0); !errors.Is(err, ErrUnknownTarget) {
+		t.Errorf("Expected ErrUnknownTarget, got %v", err)
+	}
+
+	// Removing a queued target releases waiters
+	idle := newScheduler(func(ctx context.Context, target config.Target) *ExecutionResult { return nil }, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	idle.add(ctx, config.Target{Name: "b", Host: "b", Interval: time.Hour})
+	run, running, err = idle.trigger("b", 0)
+	if err != nil || running {
+		t.Fatalf("Expected trigger to queue a run, got running=%v err=%v", running, err)
+	}
+	idle.remove("b")
+	waitClosed(t, run.Done())
+	if run.Result() != nil {
+		t.Errorf("Expected no result for a cancelled run")
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	results := make(chan *ExecutionResult, 3)
+	s := newScheduler(func(ctx context.Context, target config.Target) *ExecutionResult {
+		return <-results
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.configure(config.SchedulerConfig{
+		Backoff:        config.BackoffConfig{Initial: 10 * time.Minute, Max: time.Hour},
+		CircuitBreaker: config.CircuitBreakerConfig{Threshold: 2, Cooldown: 24 * time.Hour, Reasons: []string{ReasonDNSFailure}},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.add(ctx, config.Target{Name: "a", Host: "a", Interval: time.Hour})
+	s.mu.Lock()
+	done := s.entries["a"].run.Done()
+	s.mu.Unlock()
+
+	// expectNext checks the next run of a after the run behind done
+	expectNext := func(done <-chan struct{}, delay time.Duration, breaker string) {
+		t.Helper()
+		waitClosed(t, done)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		entry := s.entries["a"]
+		if until := time.Until(entry.next); until < delay-time.Minute || until > delay+time.Minute {
+			t.Errorf("Expected next run in %v, got %v", delay, until)
+		}
+		if entry.failures.Breaker != breaker {
+			t.Errorf("Expected breaker %s, got %s", breaker, entry.failures.Breaker)
+		}
+	}
+
+	// A failure delays the next run beyond the interval
+	results <- &ExecutionResult{Status: StatusError, Reason: ReasonDNSFailure}
+	s.start(ctx)
+	expectNext(done, time.Hour+10*time.Minute, BreakerClosed)
+
+	// The second one opens the breaker for the cooldown
+	results <- &ExecutionResult{Status: StatusError, Reason: ReasonDNSFailure}
+	run, _, err := s.trigger("a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNext(run.Done(), 24*time.Hour, BreakerOpen)
+	if state, _ := s.backoffState("a"); state.Failures != 2 || state.Backoff != 20*time.Minute {
+		t.Errorf("Expected 2 failures and 20m backoff, got %+v", state)
+	}
+
+	// Triggers don't cut the cooldown short
+	var breakerErr *BreakerOpenError
+	if _, _, err := s.trigger("a", 0); !errors.As(err, &breakerErr) || breakerErr.RetryAfter < 23*time.Hour {
+		t.Errorf("Expected BreakerOpenError for the cooldown, got %v", err)
+	}
+
+	// A successful retry once the cooldown ends resumes the target on its
+	// interval
+	results <- &ExecutionResult{Status: StatusSuccess}
+	s.mu.Lock()
+	entry := s.entries["a"]
+	run = entry.run
+	entry.base = time.Now()
+	entry.next = entry.base
+	heap.Fix(&s.queue, entry.index)
+	s.notify()
+	s.mu.Unlock()
+	expectNext(run.Done(), time.Hour, BreakerClosed)
+	if state, _ := s.backoffState("a"); state.Failures != 0 || state.Backoff != 0 {
+		t.Errorf("Expected failures to be reset, got %+v", state)
+	}
+}
+
+// waitClosed fails the test if done is not closed within a few seconds
+func waitClosed(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected run to finish")
+	}
+}
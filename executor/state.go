@@ -0,0 +1,240 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFileName is the name of the state file inside the storage directory
+const stateFileName = "state.json"
+
+// stateVersion is bumped whenever the state file format changes incompatibly
+const stateVersion = 1
+
+// saveDelay is how long the state writer waits after a change before saving,
+// so runs finishing close together are written at once
+const saveDelay = time.Second
+
+// persistedState is the on-disk representation of the executor state
+type persistedState struct {
+	Version int                         `json:"version"`
+	Targets map[string]*persistedTarget `json:"targets"`
+}
+
+// persistedTarget holds the state of a single target
+type persistedTarget struct {
+	History []*ExecutionResult `json:"history"`
+	Route   *persistedRoute    `json:"route,omitempty"`
+}
+
+// persistedRoute holds the route tracking state of a target
+type persistedRoute struct {
+	Fingerprint string    `json:"fingerprint"`
+	Changes     uint64    `json:"changes"`
+	LastChange  time.Time `json:"last_change"`
+}
+
+// EnableStorage loads the state saved in dir, if any, and persists the
+// state there shortly after every execution from now on, and on Stop. It
+// must be called before Start.
+func (e *Executor) EnableStorage(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	e.statePath = filepath.Join(dir, stateFileName)
+	if err := e.loadState(); err != nil {
+		return err
+	}
+
+	e.saveDone = make(chan struct{})
+	go e.writeState()
+	return nil
+}
+
+// requestSave asks the state writer to save the state. It never blocks;
+// requests made while a save is pending are merged into it.
+func (e *Executor) requestSave() {
+	if e.statePath == "" {
+		return
+	}
+	select {
+	case e.saveRequests <- struct{}{}:
+	default:
+	}
+}
+
+// writeState saves the state whenever it is requested, at most once per
+// saveDelay, until Stop. A single writer keeps runs from queueing up on the
+// state file.
+func (e *Executor) writeState() {
+	defer close(e.saveDone)
+
+	for {
+		select {
+		case <-e.saveRequests:
+		case <-e.saveQuit:
+			e.persistState()
+			return
+		}
+
+		select {
+		case <-time.After(saveDelay):
+		case <-e.saveQuit:
+		}
+		e.persistState()
+	}
+}
+
+// stopStorage makes the state writer save the final state and waits for it.
+// It is a no-op when storage is disabled or already stopped.
+func (e *Executor) stopStorage() {
+	if e.saveDone == nil {
+		return
+	}
+	select {
+	case <-e.saveQuit:
+	default:
+		close(e.saveQuit)
+	}
+	<-e.saveDone
+}
+
+// persistState saves the state and logs failures
+func (e *Executor) persistState() {
+	if err := e.saveState(); err != nil {
+		e.logger.Error("Failed to persist state",
+			"path", e.statePath,
+			"error", err)
+	}
+}
+
+// loadState restores results, history and routes from the state file
+func (e *Executor) loadState() error {
+	data, err := os.ReadFile(e.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if state.Version != stateVersion {
+		e.logger.Warn("Ignoring state file with unsupported version",
+			"path", e.statePath,
+			"version", state.Version)
+		return nil
+	}
+
+	e.resultsMutex.Lock()
+	defer e.resultsMutex.Unlock()
+
+	for name, target := range state.Targets {
+		history := target.History
+		if len(history) > e.historySize {
+			history = history[len(history)-e.historySize:]
+		}
+		if len(history) > 0 {
+			e.history[name] = history
+			e.results[name] = history[len(history)-1]
+		}
+
+		if target.Route != nil {
+			route := &RouteState{
+				Fingerprint: target.Route.Fingerprint,
+				Changes:     target.Route.Changes,
+				LastChange:  target.Route.LastChange,
+			}
+			// The latest successful result is the reference for changed TTLs
+			for i := len(history) - 1; i >= 0; i-- {
+				if history[i].Status == StatusSuccess && history[i].Result != nil {
+					route.last = history[i].Result
+					break
+				}
+			}
+			e.routes[name] = route
+		}
+	}
+
+	e.logger.Info("Restored state from storage",
+		"path", e.statePath,
+		"targets", len(state.Targets))
+
+	return nil
+}
+
+// saveState atomically writes the current state to the state file. Only
+// the state writer calls it.
+func (e *Executor) saveState() error {
+	state := persistedState{
+		Version: stateVersion,
+		Targets: make(map[string]*persistedTarget),
+	}
+
+	// Results are never modified once stored and histories only grow past
+	// their current length, so a copy of the slices can be encoded without
+	// holding the lock
+	e.resultsMutex.RLock()
+	for name, history := range e.history {
+		state.Targets[name] = &persistedTarget{History: history}
+	}
+	for name, route := range e.routes {
+		target, exists := state.Targets[name]
+		if !exists {
+			target = &persistedTarget{}
+			state.Targets[name] = target
+		}
+		target.Route = &persistedRoute{
+			Fingerprint: route.Fingerprint,
+			Changes:     route.Changes,
+			LastChange:  route.LastChange,
+		}
+	}
+	e.resultsMutex.RUnlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	return writeFileAtomic(e.statePath, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to write temporary state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to sync temporary state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to close temporary state file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
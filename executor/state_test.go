@@ -0,0 +1,141 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+func TestStoragePersistsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	routeA := &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "10.0.0.1", RTT: []float64{1.5}}}}
+	routeB := &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "10.0.0.2", RTT: []float64{2.5}}}}
+	routeC := &parser.NextTraceResult{Hops: []parser.Hop{{TTL: 1, IP: "10.0.0.3", RTT: []float64{3.5}}}}
+
+	first := NewExecutor("nexttrace", time.Minute, logger)
+	if err := first.EnableStorage(dir); err != nil {
+		t.Fatalf("EnableStorage failed: %v", err)
+	}
+	first.SetTestResult("a", routeC, time.Second)
+	first.SetTestResult("a", routeA, time.Second)
+	firstRoute, _ := first.GetRouteState("a")
+	first.storeResult(&ExecutionResult{
+		Target:    "a",
+		Status:    StatusError,
+		Reason:    ReasonPermissionDenied,
+		Timestamp: time.Now(),
+		Error:     errors.New("exit status 1"),
+		Stderr:    "socket: Operation not permitted",
+	})
+
+	// Stop writes the state saves still pending
+	first.Stop()
+	if _, err := os.Stat(filepath.Join(dir, stateFileName)); err != nil {
+		t.Fatalf("Expected state file to be written: %v", err)
+	}
+
+	second := NewExecutor("nexttrace", time.Minute, logger)
+	if err := second.EnableStorage(dir); err != nil {
+		t.Fatalf("EnableStorage failed: %v", err)
+	}
+
+	history := second.GetHistory("a")
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 restored results, got %d", len(history))
+	}
+	history = history[1:]
+	if history[0].Status != StatusSuccess || history[0].Result.Hops[0].IP != "10.0.0.1" {
+		t.Errorf("Unexpected first restored result: %+v", history[0])
+	}
+	if history[0].Duration != time.Second {
+		t.Errorf("Expected restored duration 1s, got %v", history[0].Duration)
+	}
+
+	latest, exists := second.GetResult("a")
+	if !exists || latest.Status != StatusError || latest.Reason != ReasonPermissionDenied || latest.Error == nil || latest.Error.Error() != "exit status 1" || latest.Stderr != "socket: Operation not permitted" {
+		t.Errorf("Unexpected restored latest result: %+v", latest)
+	}
+
+	// Route change detection carries over the restart
+	route, _ := second.GetRouteState("a")
+	if route.Changes != 1 || !route.LastChange.Equal(firstRoute.LastChange) {
+		t.Errorf("Expected restored route with 1 change at %v, got %+v", firstRoute.LastChange, route)
+	}
+	second.SetTestResult("a", routeB, time.Second)
+	route, _ = second.GetRouteState("a")
+	if route.Changes != 2 {
+		t.Errorf("Expected route change against restored route, got %d changes", route.Changes)
+	}
+}
+
+func TestStorageCoalescesSaves(t *testing.T) {
+	dir := t.TempDir()
+	e := NewExecutor("nexttrace", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := e.EnableStorage(dir); err != nil {
+		t.Fatalf("EnableStorage failed: %v", err)
+	}
+	defer e.Stop()
+
+	// Results stored in a burst are saved together once the delay passes
+	for i := 0; i < 50; i++ {
+		e.SetTestResult(fmt.Sprintf("target-%d", i), &parser.NextTraceResult{}, time.Second)
+	}
+	if _, err := os.Stat(filepath.Join(dir, stateFileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no state file before the save delay, got %v", err)
+	}
+
+	deadline := time.Now().Add(saveDelay + 2*time.Second)
+	for {
+		var state persistedState
+		if data, err := os.ReadFile(filepath.Join(dir, stateFileName)); err == nil {
+			if err := json.Unmarshal(data, &state); err != nil {
+				t.Fatalf("Failed to parse state file: %v", err)
+			}
+		}
+		if len(state.Targets) == 50 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the burst of results to be saved")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestStoragePrunesRemovedTargets(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	first := NewExecutor("nexttrace", time.Minute, logger)
+	if err := first.EnableStorage(dir); err != nil {
+		t.Fatalf("EnableStorage failed: %v", err)
+	}
+	first.SetTestResult("gone", &parser.NextTraceResult{}, time.Second)
+	first.Stop()
+
+	second := NewExecutor("/nonexistent/nexttrace", time.Minute, logger)
+	if err := second.EnableStorage(dir); err != nil {
+		t.Fatalf("EnableStorage failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	second.Start(ctx, []config.Target{{Name: "other", Host: "8.8.8.8", Interval: time.Hour}})
+	second.Stop()
+
+	if _, exists := second.GetResult("gone"); exists {
+		t.Error("Expected state of unconfigured target to be dropped on start")
+	}
+}
@@ -1,6 +1,7 @@
 package executor
 
 import (
+	"errors"
 	"time"
 
 	"github.com/vinsec/nexttrace_exporter/parser"
@@ -9,15 +10,39 @@ import (
 // SetTestResult is a helper method for testing to inject mock results
 // This should only be used in tests
 func (e *Executor) SetTestResult(targetName string, result *parser.NextTraceResult, duration time.Duration) {
-	e.resultsMutex.Lock()
-	defer e.resultsMutex.Unlock()
-
-	e.results[targetName] = &ExecutionResult{
+	e.storeResult(&ExecutionResult{
 		Target:    targetName,
 		Result:    result,
 		Duration:  duration,
 		Timestamp: time.Now(),
-		Status:    "success",
+		Status:    StatusSuccess,
 		Error:     nil,
-	}
+	})
+}
+
+// SetTestFailure is a helper method for testing to inject a failed execution
+// with the given status and error reason
+// This should only be used in tests
+func (e *Executor) SetTestFailure(targetName, status, reason string) {
+	e.storeResult(&ExecutionResult{
+		Target:    targetName,
+		Timestamp: time.Now(),
+		Status:    status,
+		Reason:    reason,
+		Error:     errors.New(reason),
+	})
+}
+
+// SetTestPartial is a helper method for testing to inject a trace that ended
+// early with the given reason but kept its completed hops
+// This should only be used in tests
+func (e *Executor) SetTestPartial(targetName string, result *parser.NextTraceResult, reason string) {
+	e.storeResult(&ExecutionResult{
+		Target:    targetName,
+		Result:    result,
+		Timestamp: time.Now(),
+		Status:    StatusPartial,
+		Reason:    reason,
+		Error:     errors.New(reason),
+	})
 }
@@ -7,15 +7,21 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vinsec/nexttrace_exporter/api"
 	"github.com/vinsec/nexttrace_exporter/collector"
 	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/discovery"
 	"github.com/vinsec/nexttrace_exporter/executor"
+	"github.com/vinsec/nexttrace_exporter/web"
 )
 
 var (
@@ -44,6 +50,36 @@ var (
 		"Timeout for nexttrace execution.",
 	).Default("2m").Duration()
 
+	nexttraceMaxOutput = kingpin.Flag(
+		"nexttrace.max-output",
+		"Maximum size of the standard output and of the standard error kept per trace.",
+	).Default("1MiB").Bytes()
+
+	probeTimeoutOffset = kingpin.Flag(
+		"probe.timeout-offset",
+		"Offset to subtract from the Prometheus scrape timeout for /probe requests.",
+	).Default("0.5s").Duration()
+
+	storagePath = kingpin.Flag(
+		"storage.path",
+		"Directory for persisting results across restarts. Disabled if empty.",
+	).Default("").String()
+
+	historySize = kingpin.Flag(
+		"storage.history-size",
+		"Number of results to keep per target.",
+	).Default("10").Int()
+
+	triggerInterval = kingpin.Flag(
+		"trigger.min-interval",
+		"Minimum time between manual runs of a target through /-/trigger.",
+	).Default("30s").Duration()
+
+	gracePeriod = kingpin.Flag(
+		"shutdown.grace-period",
+		"Maximum time to wait for cancelled traces to end on shutdown and reload.",
+	).Default("10s").Duration()
+
 	logLevel = kingpin.Flag(
 		"log.level",
 		"Log level (debug, info, warn, error).",
@@ -55,9 +91,21 @@ type Server struct {
 	collector *collector.Collector
 	registry  *prometheus.Registry
 	config    *config.Config
+	configMu  sync.RWMutex
 	logger    *slog.Logger
 	ctx       context.Context
 	cancel    context.CancelFunc
+
+	// Active targets are the static targets from the config file merged
+	// with the targets discovered from target files
+	targets         []config.Target
+	discovered      []config.Target
+	discoveryCancel context.CancelFunc
+	targetsMu       sync.Mutex // Guards targets
+	// applyMu serializes target updates, which wait for the cancelled runs
+	// of changed and removed targets. It guards discovered and
+	// discoveryCancel.
+	applyMu sync.Mutex
 }
 
 func main() {
@@ -93,8 +141,24 @@ func main() {
 		registry: prometheus.NewRegistry(),
 	}
 
+	// Restore state from storage before the first scrape
+	server.executor.SetHistorySize(*historySize)
+	server.executor.SetTriggerInterval(*triggerInterval)
+	server.executor.SetMaxOutput(int(*nexttraceMaxOutput))
+	server.executor.SetGracePeriod(*gracePeriod)
+	if *storagePath != "" {
+		if err := server.executor.EnableStorage(*storagePath); err != nil {
+			logger.Error("Failed to load stored state", "path", *storagePath, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Discover targets from target files
+	server.discovered = server.startDiscovery(cfg)
+	server.targets = server.mergeTargets(cfg.Targets, server.discovered)
+
 	// Create collector
-	server.collector = collector.NewCollector(server.executor, cfg.Targets, logger)
+	server.collector = collector.NewCollector(server.executor, server.targets, logger)
 
 	// Register collector
 	server.registry.MustRegister(server.collector)
@@ -108,7 +172,9 @@ func main() {
 	}
 
 	// Start executor
-	server.executor.Start(ctx, cfg.Targets)
+	server.executor.Configure(cfg.Scheduler)
+	server.executor.ConfigureEnrichment(cfg.Enrichment)
+	server.executor.Start(ctx, server.targets)
 
 	// Setup signal handling
 	go server.handleSignals()
@@ -148,6 +214,12 @@ func (s *Server) startHTTPServer() error {
 	// Metrics endpoint
 	mux.Handle(*metricsPath, promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
 
+	// On-demand probe endpoint
+	mux.HandleFunc("/probe", s.probeHandler)
+
+	// JSON API for targets and results, and /-/trigger to run a target now
+	api.New(s.executor, s.activeTargets, s.logger).Register(mux)
+
 	// Health check endpoint
 	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -161,84 +233,245 @@ func (s *Server) startHTTPServer() error {
 			return
 		}
 
-		if err := s.reload(); err != nil {
+		diff, err := s.reload()
+		if err != nil {
 			s.logger.Error("Failed to reload configuration", "error", err)
 			http.Error(w, fmt.Sprintf("Failed to reload: %v", err), http.StatusInternalServerError)
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "Configuration reloaded successfully\n")
+		fmt.Fprintf(w, "Configuration reloaded successfully\n%s", formatReloadDiff(diff))
 	})
 
-	// Landing page
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
-		}
-
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `<html>
-<head><title>NextTrace Exporter</title></head>
-<body>
-<h1>NextTrace Exporter</h1>
-<p><a href="%s">Metrics</a></p>
-<h2>Configured Targets</h2>
-<ul>
-`, *metricsPath)
-
-		for _, target := range s.config.Targets {
-			fmt.Fprintf(w, "<li><strong>%s</strong> (%s) - Interval: %s, Max Hops: %d</li>\n",
-				target.Name, target.Host, target.Interval, target.MaxHops)
-		}
-
-		fmt.Fprintf(w, `</ul>
-<h2>Endpoints</h2>
-<ul>
-<li><a href="/-/healthy">Health Check</a></li>
-<li><a href="/-/reload">Reload Configuration</a> (POST)</li>
-</ul>
-</body>
-</html>`)
-	})
+	// Web interface: target overview and per-target pages
+	web.New(s.executor, s.activeTargets, *metricsPath, s.logger).Register(mux)
 
 	s.logger.Info("Starting HTTP server",
 		"address", *listenAddress,
 		"metrics_path", *metricsPath)
 
+	// /probe runs a trace while the request is open, so the write timeout
+	// has to leave room for a full nexttrace execution
 	srv := &http.Server{
 		Addr:         *listenAddress,
 		Handler:      mux,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: *nexttraceTimeout + 10*time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
 	return srv.ListenAndServe()
 }
 
-func (s *Server) reload() error {
+// getConfig returns the currently loaded configuration
+func (s *Server) getConfig() *config.Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.config
+}
+
+// probeHandler runs a single trace for the requested target and module and
+// returns its metrics, in the style of blackbox_exporter's /probe
+func (s *Server) probeHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	host := params.Get("target")
+	if err := config.ValidateHost(host); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'target' parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	moduleName := params.Get("module")
+	module, exists := s.getConfig().Module(moduleName)
+	if !exists {
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+
+	timeout, err := probeTimeout(r, *nexttraceTimeout, *probeTimeoutOffset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	target := module.Target(host)
+	result, err := s.executor.RunTarget(ctx, target)
+	if err != nil {
+		// All workers stayed busy with scheduled targets or other probes
+		http.Error(w, fmt.Sprintf("Probe not started: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	s.logger.Debug("Probe finished",
+		"target", host,
+		"module", moduleName,
+		"status", result.Status,
+		"duration", result.Duration)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector.NewProbeCollector(target, result))
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// probeTimeout derives the trace timeout for a /probe request from the
+// Prometheus scrape timeout header, leaving offset for the response
+func probeTimeout(r *http.Request, fallback, offset time.Duration) (time.Duration, error) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return fallback, nil
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid X-Prometheus-Scrape-Timeout-Seconds header %q", header)
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	if timeout > fallback {
+		timeout = fallback
+	}
+	return timeout, nil
+}
+
+func (s *Server) reload() (executor.ReloadDiff, error) {
 	s.logger.Info("Reloading configuration", "config_file", *configFile)
 
 	// Load new configuration
 	cfg, err := config.LoadConfig(*configFile)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return executor.ReloadDiff{}, fmt.Errorf("failed to load configuration: %w", err)
 	}
 
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
 	// Update server state
+	s.configMu.Lock()
 	s.config = cfg
+	s.configMu.Unlock()
+
+	// Target file patterns may have changed, so discovery starts over
+	s.discovered = s.startDiscovery(cfg)
+
+	// Reload executor with new targets, only restarting the ones that changed
+	s.executor.Configure(cfg.Scheduler)
+	s.executor.ConfigureEnrichment(cfg.Enrichment)
+	targets := s.mergeTargets(cfg.Targets, s.discovered)
+	diff := s.applyTargets(targets)
+
+	s.logger.Info("Configuration reloaded successfully", "targets", len(targets))
+
+	return diff, nil
+}
+
+// activeTargets returns the targets currently being traced
+func (s *Server) activeTargets() []config.Target {
+	s.targetsMu.Lock()
+	defer s.targetsMu.Unlock()
+	return s.targets
+}
+
+// mergeTargets combines the static targets from the config file with the
+// discovered ones. Static targets win when both define the same name.
+func (s *Server) mergeTargets(static, discovered []config.Target) []config.Target {
+	names := make(map[string]bool, len(static))
+	targets := make([]config.Target, 0, len(static)+len(discovered))
+	for _, target := range static {
+		names[target.Name] = true
+		targets = append(targets, target)
+	}
+
+	for _, target := range discovered {
+		if names[target.Name] {
+			s.logger.Warn("Ignoring discovered target that clashes with a configured target",
+				"target", target.Name)
+			continue
+		}
+		names[target.Name] = true
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// applyTargets publishes a new target list and hands it to the executor and
+// collector. targetsMu is released before the executor waits for cancelled
+// runs, so the web UI and API keep answering during a reload.
+// Must be called with applyMu held.
+func (s *Server) applyTargets(targets []config.Target) executor.ReloadDiff {
+	s.targetsMu.Lock()
+	s.targets = targets
+	s.targetsMu.Unlock()
+
+	diff := s.executor.Reload(s.ctx, targets)
+	s.collector.UpdateTargets(targets)
+	return diff
+}
+
+// startDiscovery stops any running target file discovery, loads the target
+// files of cfg and starts watching them. It returns the discovered targets.
+// Must be called with applyMu held, except during startup.
+func (s *Server) startDiscovery(cfg *config.Config) []config.Target {
+	if s.discoveryCancel != nil {
+		s.discoveryCancel()
+		s.discoveryCancel = nil
+	}
 
-	// Reload executor with new targets
-	s.executor.Reload(s.ctx, cfg.Targets)
+	if len(cfg.TargetFiles) == 0 {
+		return nil
+	}
 
-	// Update collector targets
-	s.collector.UpdateTargets(cfg.Targets)
+	d := discovery.NewFileDiscovery(cfg.TargetFiles, s.logger)
+	discovered := d.Refresh()
+	s.logger.Info("Loaded targets from target files",
+		"patterns", cfg.TargetFiles,
+		"targets", len(discovered))
 
-	s.logger.Info("Configuration reloaded successfully", "targets", len(cfg.Targets))
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.discoveryCancel = cancel
+	go d.Run(ctx, discovered, func(targets []config.Target) {
+		s.applyMu.Lock()
+		defer s.applyMu.Unlock()
 
-	return nil
+		// A reload may have replaced this discovery in the meantime
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.discovered = targets
+		merged := s.mergeTargets(s.getConfig().Targets, targets)
+		diff := s.applyTargets(merged)
+		s.logger.Info("Target files changed",
+			"targets", len(merged),
+			"added", diff.Added,
+			"removed", diff.Removed,
+			"changed", diff.Changed)
+	})
+
+	return discovered
+}
+
+// formatReloadDiff renders a reload diff for the /-/reload response body
+func formatReloadDiff(diff executor.ReloadDiff) string {
+	var b strings.Builder
+	for _, section := range []struct {
+		name    string
+		targets []string
+	}{
+		{"added", diff.Added},
+		{"removed", diff.Removed},
+		{"changed", diff.Changed},
+	} {
+		fmt.Fprintf(&b, "%s: %s\n", section.name, strings.Join(section.targets, ", "))
+	}
+	fmt.Fprintf(&b, "unchanged: %d\n", len(diff.Unchanged))
+	return b.String()
 }
 
 func (s *Server) handleSignals() {
@@ -249,7 +482,7 @@ func (s *Server) handleSignals() {
 		switch sig {
 		case syscall.SIGHUP:
 			s.logger.Info("Received SIGHUP, reloading configuration")
-			if err := s.reload(); err != nil {
+			if _, err := s.reload(); err != nil {
 				s.logger.Error("Failed to reload configuration", "error", err)
 			}
 		case syscall.SIGINT, syscall.SIGTERM:
@@ -260,8 +493,10 @@ func (s *Server) handleSignals() {
 	}
 }
 
+// shutdown cancels all traces and waits, up to the grace period, until the
+// trace tools have exited
 func (s *Server) shutdown() {
-	s.logger.Info("Shutting down...")
+	s.logger.Info("Shutting down...", "grace_period", *gracePeriod)
 	s.cancel()
 	s.executor.Stop()
 	s.logger.Info("Shutdown complete")
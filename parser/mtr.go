@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MTRRawResult represents the raw JSON output from mtr --json
+type MTRRawResult struct {
+	Report struct {
+		MTR struct {
+			Src string `json:"src"`
+			Dst string `json:"dst"`
+		} `json:"mtr"`
+		Hubs []MTRHub `json:"hubs"`
+	} `json:"report"`
+}
+
+// MTRHub represents one hop of an mtr report. Statistics are in
+// milliseconds, except Loss which is in percent.
+type MTRHub struct {
+	Count json.Number `json:"count"` // Older mtr versions report it as a string
+	Host  string      `json:"host"`
+	ASN   string      `json:"ASN"`
+	Loss  float64     `json:"Loss%"`
+	Sent  int         `json:"Snt"`
+	Last  float64     `json:"Last"`
+	Avg   float64     `json:"Avg"`
+	Best  float64     `json:"Best"`
+	Worst float64     `json:"Wrst"`
+	StDev float64     `json:"StDev"`
+}
+
+// ParseMTROutput parses the JSON report of mtr --json. mtr only reports
+// aggregate RTTs per hop, which end up in Hop.Summary.
+func ParseMTROutput(data []byte) (*NextTraceResult, error) {
+	// mtr may print warnings before the report
+	if start := bytes.IndexByte(data, '{'); start > 0 {
+		data = data[start:]
+	}
+
+	var raw MTRRawResult
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse mtr JSON: %w", err)
+	}
+
+	result := &NextTraceResult{
+		Target: raw.Report.MTR.Dst,
+		Hops:   make([]Hop, 0, len(raw.Report.Hubs)),
+	}
+
+	for _, hub := range raw.Report.Hubs {
+		ttl, err := strconv.Atoi(hub.Count.String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid hop number %q: %w", hub.Count, err)
+		}
+
+		hop := Hop{
+			TTL:  ttl,
+			RTT:  []float64{},
+			Loss: hub.Loss / 100,
+		}
+
+		// "???" marks a hop that never answered
+		if hub.Host != "" && hub.Host != "???" {
+			hostname, ip := splitHostAddress(hub.Host)
+			asn := strings.TrimPrefix(hub.ASN, "AS")
+			if asn == "???" {
+				asn = ""
+			}
+
+			hop.IP = ip
+			hop.Hostname = hostname
+			hop.ASN = asn
+			hop.Responders = []Responder{{
+				IP:       ip,
+				Hostname: hostname,
+				RTT:      []float64{},
+				ASN:      asn,
+				Share:    1 - hop.Loss,
+			}}
+			hop.Summary = &RTTSummary{
+				Min:    hub.Best,
+				Avg:    hub.Avg,
+				Max:    hub.Worst,
+				StdDev: hub.StDev,
+			}
+		}
+
+		result.Hops = append(result.Hops, hop)
+	}
+
+	return result, nil
+}
+
+// splitHostAddress splits the "hostname (address)" form printed by mtr and
+// traceroute when names are resolved. A bare address has no hostname.
+func splitHostAddress(host string) (hostname, ip string) {
+	open := strings.LastIndexByte(host, '(')
+	if open == -1 || !strings.HasSuffix(host, ")") {
+		return "", host
+	}
+	hostname = strings.TrimSpace(host[:open])
+	ip = host[open+1 : len(host)-1]
+	if hostname == ip {
+		hostname = ""
+	}
+	return hostname, ip
+}
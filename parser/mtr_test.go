@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestParseMTROutput(t *testing.T) {
+	data := []byte(`{
+  "report": {
+    "mtr": {
+      "src": "probe-1",
+      "dst": "8.8.8.8",
+      "tos": 0,
+      "tests": 10,
+      "psize": "64",
+      "bitpattern": "0x00"
+    },
+    "hubs": [
+      {
+        "count": 1,
+        "host": "_gateway (192.168.1.1)",
+        "Loss%": 0.0,
+        "Snt": 10,
+        "Last": 0.52,
+        "Avg": 0.61,
+        "Best": 0.45,
+        "Wrst": 1.02,
+        "StDev": 0.16
+      },
+      {
+        "count": 2,
+        "host": "???",
+        "Loss%": 100.0,
+        "Snt": 10,
+        "Last": 0.0,
+        "Avg": 0.0,
+        "Best": 0.0,
+        "Wrst": 0.0,
+        "StDev": 0.0
+      },
+      {
+        "count": "3",
+        "host": "8.8.8.8 (8.8.8.8)",
+        "ASN": "AS15169",
+        "Loss%": 20.0,
+        "Snt": 10,
+        "Last": 10.1,
+        "Avg": 10.5,
+        "Best": 9.8,
+        "Wrst": 12.3,
+        "StDev": 0.7
+      }
+    ]
+  }
+}`)
+
+	result, err := ParseMTROutput(data)
+	if err != nil {
+		t.Fatalf("ParseMTROutput failed: %v", err)
+	}
+
+	if result.Target != "8.8.8.8" {
+		t.Errorf("Expected target 8.8.8.8, got %s", result.Target)
+	}
+	if len(result.Hops) != 3 {
+		t.Fatalf("Expected 3 hops, got %d", len(result.Hops))
+	}
+
+	gateway := result.Hops[0]
+	if gateway.TTL != 1 || gateway.IP != "192.168.1.1" || gateway.Hostname != "_gateway" {
+		t.Errorf("Unexpected first hop: ttl=%d ip=%s hostname=%s", gateway.TTL, gateway.IP, gateway.Hostname)
+	}
+	if gateway.AverageRTT() != 0.61 || gateway.MinRTT() != 0.45 || gateway.MaxRTT() != 1.02 || gateway.StdDevRTT() != 0.16 {
+		t.Errorf("Expected RTT statistics from the summary, got avg=%v min=%v max=%v stddev=%v",
+			gateway.AverageRTT(), gateway.MinRTT(), gateway.MaxRTT(), gateway.StdDevRTT())
+	}
+
+	silent := result.Hops[1]
+	if silent.HasValidIP() || silent.HasRTT() || silent.Loss != 1 {
+		t.Errorf("Expected silent hop with full loss, got ip=%s loss=%v", silent.IP, silent.Loss)
+	}
+
+	dest := result.Hops[2]
+	if dest.TTL != 3 {
+		t.Errorf("Expected TTL 3 from string count, got %d", dest.TTL)
+	}
+	if dest.Hostname != "" {
+		t.Errorf("Expected no hostname for unresolved address, got %s", dest.Hostname)
+	}
+	if dest.ASN != "15169" {
+		t.Errorf("Expected ASN 15169, got %s", dest.ASN)
+	}
+	if dest.Loss != 0.2 {
+		t.Errorf("Expected loss 0.2, got %v", dest.Loss)
+	}
+	if len(dest.Responders) != 1 || dest.Responders[0].Share != 0.8 {
+		t.Errorf("Expected one responder with share 0.8, got %+v", dest.Responders)
+	}
+}
+
+func TestParseMTROutputInvalid(t *testing.T) {
+	if _, err := ParseMTROutput([]byte("mtr: Failure to open IPv4 sockets")); err == nil {
+		t.Error("Expected error for non-JSON output")
+	}
+}
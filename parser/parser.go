@@ -1,10 +1,15 @@
 package parser
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
+	"net"
 	"regexp"
+	"sort"
+	"strings"
 )
 
 // NextTraceRawResult represents the raw JSON output from nexttrace -j
@@ -54,24 +59,58 @@ type GeoInfo struct {
 
 // NextTraceResult represents the processed result
 type NextTraceResult struct {
-	Target string `json:"target"`
-	Hops   []Hop  `json:"hops"`
+	Target   string `json:"target"`
+	TargetIP string `json:"target_ip,omitempty"` // Address that was traced, empty if unknown
+	Hops     []Hop  `json:"hops"`
 }
 
 // Hop represents aggregated data for a single hop (TTL level)
 type Hop struct {
-	TTL      int       `json:"ttl"`
-	IP       string    `json:"ip"`
-	Hostname string    `json:"hostname"`
-	RTT      []float64 `json:"rtt"` // RTT in milliseconds
-	Loss     float64   `json:"loss"`
-	ASN      string    `json:"asn"`
-	Location string    `json:"location"`
+	TTL         int         `json:"ttl"`
+	IP          string      `json:"ip"`
+	Hostname    string      `json:"hostname"`
+	RTT         []float64   `json:"rtt"` // RTT in milliseconds
+	Loss        float64     `json:"loss"`
+	ASN         string      `json:"asn"`
+	Location    string      `json:"location"`
+	ASName      string      `json:"as_name,omitempty"`
+	CountryCode string      `json:"country_code,omitempty"` // ISO 3166-1 alpha-2
+	Latitude    float64     `json:"latitude,omitempty"`
+	Longitude   float64     `json:"longitude,omitempty"`
+	Responders  []Responder `json:"responders"`
+	// Summary holds the RTT statistics of tools that only report aggregates
+	// instead of individual RTT samples, such as mtr
+	Summary *RTTSummary `json:"summary,omitempty"`
 }
 
-// ParseNextTraceOutput parses the JSON output from nexttrace -j command
+// RTTSummary holds aggregate RTT statistics for a hop in milliseconds
+type RTTSummary struct {
+	Min    float64 `json:"min"`
+	Avg    float64 `json:"avg"`
+	Max    float64 `json:"max"`
+	StdDev float64 `json:"stddev"`
+}
+
+// Responder represents a single device that answered probes at a hop.
+// Load-balanced (ECMP) paths show up as several responders at the same TTL.
+type Responder struct {
+	IP          string    `json:"ip"`
+	Hostname    string    `json:"hostname"`
+	RTT         []float64 `json:"rtt"` // RTT in milliseconds
+	ASN         string    `json:"asn"`
+	Location    string    `json:"location"`
+	Share       float64   `json:"share"` // Fraction of probes at this TTL answered by this responder (0-1)
+	ASName      string    `json:"as_name,omitempty"`
+	CountryCode string    `json:"country_code,omitempty"`
+	Latitude    float64   `json:"latitude,omitempty"`
+	Longitude   float64   `json:"longitude,omitempty"`
+}
+
+// ParseNextTraceOutput parses the JSON output from nexttrace -j command. The
+// exporter runs nexttrace with --raw and parses it with ParseNextTraceRawOutput,
+// this is kept for JSON reports produced outside of it.
 func ParseNextTraceOutput(data []byte) (*NextTraceResult, error) {
-	// Clean the output: remove ANSI escape sequences and extract only the JSON part
+	// Only stdout is parsed, so anything besides color codes is an error
 	cleanedData := cleanNextTraceOutput(data)
 
 	var raw NextTraceRawResult
@@ -89,63 +128,11 @@ func ParseNextTraceOutput(data []byte) (*NextTraceResult, error) {
 			continue
 		}
 
-		hop := Hop{
-			RTT: make([]float64, 0, len(probes)),
-		}
-
-		successCount := 0
-		var firstValidIP string
-		var firstValidHostname string
-		var firstValidASN string
-		var firstValidLocation string
-
-		// Aggregate data from all probes at this TTL
+		converted := make([]Probe, 0, len(probes))
 		for _, probe := range probes {
-			hop.TTL = probe.TTL
-
-			if probe.Success && probe.Address != nil {
-				successCount++
-
-				// Convert RTT from nanoseconds to milliseconds
-				if probe.RTT > 0 {
-					rttMs := float64(probe.RTT) / 1_000_000.0
-					hop.RTT = append(hop.RTT, rttMs)
-				}
-
-				// Use the first valid IP/hostname/ASN we see
-				if firstValidIP == "" && probe.Address.IP != "" {
-					firstValidIP = probe.Address.IP
-				}
-				if firstValidHostname == "" && probe.Hostname != "" {
-					firstValidHostname = probe.Hostname
-				}
-
-				// Extract geo information
-				if probe.Geo != nil {
-					if firstValidASN == "" && probe.Geo.ASNumber != "" {
-						firstValidASN = probe.Geo.ASNumber
-					}
-					if firstValidLocation == "" {
-						if probe.Geo.CityEn != "" && probe.Geo.CountryEn != "" {
-							firstValidLocation = probe.Geo.CityEn + ", " + probe.Geo.CountryEn
-						} else if probe.Geo.CountryEn != "" {
-							firstValidLocation = probe.Geo.CountryEn
-						}
-					}
-				}
-			}
-		}
-
-		hop.IP = firstValidIP
-		hop.Hostname = firstValidHostname
-		hop.ASN = firstValidASN
-		hop.Location = firstValidLocation
-
-		// Calculate packet loss ratio
-		totalProbes := len(probes)
-		if totalProbes > 0 {
-			hop.Loss = float64(totalProbes-successCount) / float64(totalProbes)
+			converted = append(converted, convertProbe(probe))
 		}
+		hop := NewHop(converted)
 
 		// Only add hops with valid data
 		if hop.TTL > 0 {
@@ -156,17 +143,131 @@ func ParseNextTraceOutput(data []byte) (*NextTraceResult, error) {
 	return result, nil
 }
 
-// AverageRTT calculates the average RTT from a slice of RTT values
+// convertProbe converts a single nexttrace probe result
+func convertProbe(detail HopDetail) Probe {
+	probe := Probe{TTL: detail.TTL}
+	if !detail.Success || detail.Address == nil {
+		return probe
+	}
+
+	probe.Success = true
+	probe.IP = detail.Address.IP
+	probe.Hostname = detail.Hostname
+	// Convert RTT from nanoseconds to milliseconds
+	if detail.RTT > 0 {
+		probe.RTT = float64(detail.RTT) / 1_000_000.0
+	}
+	if detail.Geo != nil {
+		probe.ASN = detail.Geo.ASNumber
+		probe.Location = formatLocation(detail.Geo)
+		probe.ASName = detail.Geo.Owner
+		if probe.ASName == "" {
+			probe.ASName = detail.Geo.ISP
+		}
+		probe.Latitude = detail.Geo.Lat
+		probe.Longitude = detail.Geo.Lng
+	}
+	return probe
+}
+
+// AverageRTT calculates the average RTT of the hop's samples, falling back
+// to the summary
 func (h *Hop) AverageRTT() float64 {
+	if len(h.RTT) == 0 && h.Summary != nil {
+		return h.Summary.Avg
+	}
+	return averageRTT(h.RTT)
+}
+
+// MinRTT returns the lowest RTT at this hop, or 0 if there are none. Like
+// MaxRTT and StdDevRTT it falls back to the summary when there are no samples.
+func (h *Hop) MinRTT() float64 {
 	if len(h.RTT) == 0 {
+		if h.Summary != nil {
+			return h.Summary.Min
+		}
 		return 0.0
 	}
 
-	var sum float64
+	minRTT := h.RTT[0]
+	for _, rtt := range h.RTT[1:] {
+		if rtt < minRTT {
+			minRTT = rtt
+		}
+	}
+	return minRTT
+}
+
+// MaxRTT returns the highest RTT at this hop, or 0 if there are none
+func (h *Hop) MaxRTT() float64 {
+	if len(h.RTT) == 0 && h.Summary != nil {
+		return h.Summary.Max
+	}
+
+	var maxRTT float64
 	for _, rtt := range h.RTT {
-		sum += rtt
+		if rtt > maxRTT {
+			maxRTT = rtt
+		}
 	}
-	return sum / float64(len(h.RTT))
+	return maxRTT
+}
+
+// HasRTT reports whether RTT samples or a summary are known for this hop
+func (h *Hop) HasRTT() bool {
+	return len(h.RTT) > 0 || h.Summary != nil
+}
+
+// MedianRTT returns the median RTT at this hop, or 0 if there are none
+func (h *Hop) MedianRTT() float64 {
+	return h.PercentileRTT(50)
+}
+
+// PercentileRTT returns the p-th percentile (0-100) of the RTTs at this hop,
+// linearly interpolated between the closest samples, or 0 if there are none
+func (h *Hop) PercentileRTT(p float64) float64 {
+	if len(h.RTT) == 0 {
+		return 0.0
+	}
+
+	sorted := make([]float64, len(h.RTT))
+	copy(sorted, h.RTT)
+	sort.Float64s(sorted)
+
+	if p <= 0 {
+		return sorted[0]
+	}
+	if p >= 100 {
+		return sorted[len(sorted)-1]
+	}
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// StdDevRTT returns the population standard deviation of the RTTs at this
+// hop (jitter), or 0 if there are fewer than two samples
+func (h *Hop) StdDevRTT() float64 {
+	if len(h.RTT) == 0 && h.Summary != nil {
+		return h.Summary.StdDev
+	}
+	if len(h.RTT) < 2 {
+		return 0.0
+	}
+
+	mean := h.AverageRTT()
+	var variance float64
+	for _, rtt := range h.RTT {
+		variance += (rtt - mean) * (rtt - mean)
+	}
+	return math.Sqrt(variance / float64(len(h.RTT)))
+}
+
+// AverageRTT calculates the average RTT of the probes answered by this responder
+func (r *Responder) AverageRTT() float64 {
+	return averageRTT(r.RTT)
 }
 
 // HasValidIP checks if the hop has a valid IP address
@@ -174,18 +275,213 @@ func (h *Hop) HasValidIP() bool {
 	return h.IP != "" && h.IP != "*"
 }
 
-// cleanNextTraceOutput removes ANSI escape sequences and extracts the JSON part
-func cleanNextTraceOutput(data []byte) []byte {
-	// Remove ANSI escape sequences (color codes)
-	ansiRegex := regexp.MustCompile(`\x1b\[[0-9;]*m`)
-	cleaned := ansiRegex.ReplaceAll(data, []byte(""))
+// averageRTT returns the mean of the given RTT values, or 0 if there are none
+func averageRTT(rtts []float64) float64 {
+	if len(rtts) == 0 {
+		return 0.0
+	}
 
-	// Find the first { which marks the start of JSON
-	jsonStart := bytes.IndexByte(cleaned, '{')
-	if jsonStart == -1 {
-		return data // Return original if no JSON found
+	var sum float64
+	for _, rtt := range rtts {
+		sum += rtt
 	}
+	return sum / float64(len(rtts))
+}
 
-	// Extract from { to the end
-	return cleaned[jsonStart:]
+// formatLocation builds a human readable location from nexttrace geo data
+func formatLocation(geo *GeoInfo) string {
+	if geo.CityEn != "" && geo.CountryEn != "" {
+		return geo.CityEn + ", " + geo.CountryEn
+	}
+	return geo.CountryEn
+}
+
+// SetTargetIP picks the traced address among the addresses the target host
+// resolves to: the one that answered the trace if any, the first otherwise
+func (r *NextTraceResult) SetTargetIP(addrs []string) {
+	for _, addr := range addrs {
+		r.TargetIP = addr
+		if _, _, found := r.Destination(); found {
+			return
+		}
+	}
+	if len(addrs) > 0 {
+		r.TargetIP = addrs[0]
+	} else {
+		r.TargetIP = ""
+	}
+}
+
+// Destination returns the first hop at which the target IP answered, along
+// with the responder for the target IP. found is false when the target IP is
+// unknown or the trace never reached it.
+func (r *NextTraceResult) Destination() (hop *Hop, responder *Responder, found bool) {
+	target := net.ParseIP(r.TargetIP)
+	if target == nil {
+		return nil, nil, false
+	}
+	for i := range r.Hops {
+		for j := range r.Hops[i].Responders {
+			if target.Equal(net.ParseIP(r.Hops[i].Responders[j].IP)) {
+				return &r.Hops[i], &r.Hops[i].Responders[j], true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// DestinationRTT returns the average RTT of the destination in milliseconds,
+// or 0 if it wasn't reached or the RTT is unknown
+func (r *NextTraceResult) DestinationRTT() float64 {
+	hop, responder, found := r.Destination()
+	if !found {
+		return 0.0
+	}
+	if len(responder.RTT) > 0 {
+		return responder.AverageRTT()
+	}
+	// Tools reporting aggregates have no per-responder samples
+	return hop.AverageRTT()
+}
+
+// DestinationLoss returns the fraction of probes at the destination's TTL
+// that the destination didn't answer, or 1 if it wasn't reached
+func (r *NextTraceResult) DestinationLoss() float64 {
+	_, responder, found := r.Destination()
+	if !found {
+		return 1.0
+	}
+	return 1 - responder.Share
+}
+
+// UnresponsiveTrailingHops returns the number of hops at the end of the
+// trace where nothing answered
+func (r *NextTraceResult) UnresponsiveTrailingHops() int {
+	count := 0
+	for i := len(r.Hops) - 1; i >= 0 && len(r.Hops[i].Responders) == 0; i-- {
+		count++
+	}
+	return count
+}
+
+// ForwardedLoss returns the loss that persists past each hop: the lowest
+// loss at the hop and all hops after it. Loss at a hop that later hops don't
+// see is caused by the router rate limiting its replies, not by dropped
+// packets. The result is aligned with Hops.
+func (r *NextTraceResult) ForwardedLoss() []float64 {
+	forwarded := make([]float64, len(r.Hops))
+	minLoss := 1.0
+	for i := len(r.Hops) - 1; i >= 0; i-- {
+		if r.Hops[i].Loss < minLoss {
+			minLoss = r.Hops[i].Loss
+		}
+		forwarded[i] = minLoss
+	}
+	return forwarded
+}
+
+// PathKeys returns one identifier per hop describing the route: the sorted
+// responder IPs, so ECMP hops answered by the same set of routers in a
+// different order keep their key, the hop IP or ASN when no responder is
+// known, or "*" for hops that did not answer. Trailing unresponsive hops are
+// dropped so runs that give up at different TTLs past the last responder
+// still describe the same route.
+func (r *NextTraceResult) PathKeys() []string {
+	keys := make([]string, 0, len(r.Hops))
+	for i := range r.Hops {
+		keys = append(keys, r.Hops[i].pathKey())
+	}
+
+	for len(keys) > 0 && keys[len(keys)-1] == "*" {
+		keys = keys[:len(keys)-1]
+	}
+	return keys
+}
+
+// pathKey returns the identifier of the hop in PathKeys
+func (h *Hop) pathKey() string {
+	var ips []string
+	seen := make(map[string]bool)
+	for _, responder := range h.Responders {
+		if responder.IP != "" && responder.IP != "*" && !seen[responder.IP] {
+			seen[responder.IP] = true
+			ips = append(ips, responder.IP)
+		}
+	}
+
+	switch {
+	case len(ips) > 0:
+		sort.Strings(ips)
+		return strings.Join(ips, ",")
+	case h.HasValidIP():
+		return h.IP
+	case h.ASN != "":
+		return "AS" + h.ASN
+	default:
+		return "*"
+	}
+}
+
+// Fingerprint returns a short stable hash of the route, see PathKeys
+func (r *NextTraceResult) Fingerprint() string {
+	sum := sha256.Sum256([]byte(strings.Join(r.PathKeys(), ">")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// MergeSilentHops returns cur with each hop that did not answer before its
+// last responsive hop replaced by the hop at the same position in prev. A
+// router that doesn't answer every run is assumed to still be there, so the
+// route of the result only changes when a hop answers from somewhere else.
+func MergeSilentHops(prev, cur *NextTraceResult) *NextTraceResult {
+	prevKeys := prev.PathKeys()
+	curKeys := cur.PathKeys()
+
+	merged := *cur
+	merged.Hops = append([]Hop(nil), cur.Hops...)
+	for i, key := range curKeys {
+		if key == "*" && i < len(prevKeys) {
+			merged.Hops[i] = prev.Hops[i]
+			merged.Hops[i].TTL = cur.Hops[i].TTL
+		}
+	}
+	return &merged
+}
+
+// ChangedTTLs returns the TTLs at which the route differs between two
+// results. A hop that did not answer in either result matches any key.
+func ChangedTTLs(prev, cur *NextTraceResult) []int {
+	prevKeys := prev.PathKeys()
+	curKeys := cur.PathKeys()
+
+	n := len(prevKeys)
+	if len(curKeys) > n {
+		n = len(curKeys)
+	}
+
+	var changed []int
+	for i := 0; i < n; i++ {
+		if i < len(prevKeys) && i < len(curKeys) &&
+			(prevKeys[i] == curKeys[i] || prevKeys[i] == "*" || curKeys[i] == "*") {
+			continue
+		}
+		// A silent hop past the end of the other route is no change either
+		if (i < len(curKeys) && curKeys[i] == "*") || (i >= len(curKeys) && prevKeys[i] == "*") {
+			continue
+		}
+		// Keys map one to one onto the leading hops of each result
+		if i < len(cur.Hops) {
+			changed = append(changed, cur.Hops[i].TTL)
+		} else {
+			changed = append(changed, prev.Hops[i].TTL)
+		}
+	}
+	return changed
+}
+
+// ansiPattern matches ANSI escape sequences (color codes)
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// cleanNextTraceOutput removes ANSI escape sequences (color codes)
+func cleanNextTraceOutput(data []byte) []byte {
+	return ansiPattern.ReplaceAll(data, nil)
 }
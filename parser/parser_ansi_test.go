@@ -11,8 +11,8 @@ func TestCleanNextTraceOutput(t *testing.T) {
 		expected string
 	}{
 		{
-			name:     "output with ANSI codes",
-			input:    "\x1b[37;1m[NextTrace API]\x1b[0;22m preferred API IP - \x1b[32;1m[2606:4700:20::681a:c97]\x1b[0;22m\n{\"Hops\":[]}",
+			name:     "JSON with ANSI codes",
+			input:    "\x1b[32;1m{\"Hops\":[]}\x1b[0;22m",
 			expected: "{\"Hops\":[]}",
 		},
 		{
@@ -21,9 +21,10 @@ func TestCleanNextTraceOutput(t *testing.T) {
 			expected: "{\"Hops\":[]}",
 		},
 		{
-			name:     "complex ANSI with actual JSON",
+			// Log lines are no longer cut off, ParseNextTraceOutput rejects them
+			name:     "log line before JSON",
 			input:    "\x1b[37;1m[NextTrace API]\x1b[0;22m test\n{\"Hops\":[[{\"Success\":true}]]}",
-			expected: "{\"Hops\":[[{\"Success\":true}]]}",
+			expected: "[NextTrace API] test\n{\"Hops\":[[{\"Success\":true}]]}",
 		},
 	}
 
@@ -36,3 +37,10 @@ func TestCleanNextTraceOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNextTraceOutputLeadingBytes(t *testing.T) {
+	input := "\x1b[37;1m[NextTrace API]\x1b[0;22m preferred API IP - \x1b[32;1m[2606:4700:20::681a:c97]\x1b[0;22m\n{\"Hops\":[]}"
+	if _, err := ParseNextTraceOutput([]byte(input)); err == nil {
+		t.Error("Expected an error for output with a log line before the JSON")
+	}
+}
@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"math"
+	"reflect"
 	"testing"
 )
 
@@ -122,8 +124,8 @@ func TestParseNextTraceOutput(t *testing.T) {
 						"isp": "Test ISP",
 						"domain": "",
 						"whois": "",
-						"lat": 0,
-						"lng": 0,
+						"lat": 50.11,
+						"lng": 8.68,
 						"prefix": "",
 						"router": {},
 						"source": ""
@@ -242,6 +244,12 @@ func TestParseNextTraceOutput(t *testing.T) {
 	if hop2.Location != "City, Country" {
 		t.Errorf("Expected hop2 location 'City, Country', got %s", hop2.Location)
 	}
+	if hop2.ASName != "Test ISP" {
+		t.Errorf("Expected hop2 AS name 'Test ISP', got %s", hop2.ASName)
+	}
+	if hop2.Latitude != 50.11 || hop2.Longitude != 8.68 {
+		t.Errorf("Expected hop2 coordinates 50.11/8.68, got %v/%v", hop2.Latitude, hop2.Longitude)
+	}
 
 	// Test packet loss (all successful, should be 0)
 	if hop1.Loss != 0.0 {
@@ -459,3 +467,363 @@ func TestParseNextTraceOutputAllTimeout(t *testing.T) {
 		t.Errorf("Expected empty IP, got %s", hop.IP)
 	}
 }
+
+func TestParseNextTraceOutputMultipath(t *testing.T) {
+	// Load-balanced hop: two routers answer at TTL 2, one probe is lost
+	jsonData := []byte(`{
+		"Hops": [
+			[
+				{
+					"Success": true,
+					"Address": {"IP": "10.0.0.1", "Zone": ""},
+					"Hostname": "edge-a.example",
+					"TTL": 2,
+					"RTT": 2000000,
+					"Error": null,
+					"Geo": {"asnumber": "64500", "country_en": "Germany", "city_en": "Frankfurt"},
+					"Lang": "en",
+					"MPLS": null
+				},
+				{
+					"Success": true,
+					"Address": {"IP": "10.0.0.2", "Zone": ""},
+					"Hostname": "edge-b.example",
+					"TTL": 2,
+					"RTT": 4000000,
+					"Error": null,
+					"Geo": {"asnumber": "64501", "country_en": "Germany"},
+					"Lang": "en",
+					"MPLS": null
+				},
+				{
+					"Success": true,
+					"Address": {"IP": "10.0.0.1", "Zone": ""},
+					"Hostname": "edge-a.example",
+					"TTL": 2,
+					"RTT": 3000000,
+					"Error": null,
+					"Geo": null,
+					"Lang": "en",
+					"MPLS": null
+				},
+				{
+					"Success": false,
+					"Address": null,
+					"Hostname": "",
+					"TTL": 2,
+					"RTT": 0,
+					"Error": {},
+					"Geo": null,
+					"Lang": "",
+					"MPLS": null
+				}
+			]
+		],
+		"TraceMapUrl": ""
+	}`)
+
+	result, err := ParseNextTraceOutput(jsonData)
+	if err != nil {
+		t.Fatalf("ParseNextTraceOutput failed: %v", err)
+	}
+
+	if len(result.Hops) != 1 {
+		t.Fatalf("Expected 1 hop, got %d", len(result.Hops))
+	}
+
+	hop := result.Hops[0]
+	if len(hop.Responders) != 2 {
+		t.Fatalf("Expected 2 responders, got %d", len(hop.Responders))
+	}
+
+	// The first responder is reported as the hop itself
+	if hop.IP != "10.0.0.1" {
+		t.Errorf("Expected hop IP 10.0.0.1, got %s", hop.IP)
+	}
+	if hop.Location != "Frankfurt, Germany" {
+		t.Errorf("Expected hop location 'Frankfurt, Germany', got %s", hop.Location)
+	}
+
+	a, b := hop.Responders[0], hop.Responders[1]
+	if a.IP != "10.0.0.1" || b.IP != "10.0.0.2" {
+		t.Errorf("Expected responders 10.0.0.1 and 10.0.0.2, got %s and %s", a.IP, b.IP)
+	}
+	if len(a.RTT) != 2 || a.AverageRTT() != 2.5 {
+		t.Errorf("Expected responder a to have 2 RTTs averaging 2.5ms, got %v", a.RTT)
+	}
+	if b.Hostname != "edge-b.example" || b.ASN != "64501" || b.Location != "Germany" {
+		t.Errorf("Unexpected responder b details: %+v", b)
+	}
+	if a.Share != 0.5 || b.Share != 0.25 {
+		t.Errorf("Expected shares 0.5 and 0.25, got %.2f and %.2f", a.Share, b.Share)
+	}
+	if hop.Loss != 0.25 {
+		t.Errorf("Expected loss 0.25, got %.2f", hop.Loss)
+	}
+	if len(hop.RTT) != 3 {
+		t.Errorf("Expected 3 RTT values at hop, got %d", len(hop.RTT))
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	base := &NextTraceResult{Hops: []Hop{
+		{TTL: 1, IP: "192.168.1.1"},
+		{TTL: 2, ASN: "64500"},
+		{TTL: 3, IP: "10.0.0.1"},
+	}}
+
+	// Unresponsive trailing hops do not change the route
+	trailing := &NextTraceResult{Hops: append(append([]Hop{}, base.Hops...), Hop{TTL: 4}, Hop{TTL: 5})}
+	if base.Fingerprint() != trailing.Fingerprint() {
+		t.Errorf("Expected trailing unresponsive hops to be ignored")
+	}
+
+	// Same length reroute changes the fingerprint
+	rerouted := &NextTraceResult{Hops: []Hop{
+		{TTL: 1, IP: "192.168.1.1"},
+		{TTL: 2, ASN: "64500"},
+		{TTL: 3, IP: "10.0.0.2"},
+	}}
+	if base.Fingerprint() == rerouted.Fingerprint() {
+		t.Errorf("Expected rerouted path to have a different fingerprint")
+	}
+
+	changed := ChangedTTLs(base, rerouted)
+	if len(changed) != 1 || changed[0] != 3 {
+		t.Errorf("Expected TTL 3 to change, got %v", changed)
+	}
+
+	longer := &NextTraceResult{Hops: append(append([]Hop{}, base.Hops...), Hop{TTL: 4, IP: "10.0.0.9"})}
+	changed = ChangedTTLs(base, longer)
+	if len(changed) != 1 || changed[0] != 4 {
+		t.Errorf("Expected TTL 4 to change, got %v", changed)
+	}
+}
+
+func TestPathKeysResponders(t *testing.T) {
+	// ECMP hops are keyed on the set of responders, whatever their order
+	// and whichever of them nexttrace reports as the hop IP
+	a := &NextTraceResult{Hops: []Hop{
+		{TTL: 1, IP: "10.0.0.1", Responders: []Responder{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}},
+	}}
+	b := &NextTraceResult{Hops: []Hop{
+		{TTL: 1, IP: "10.0.0.2", Responders: []Responder{{IP: "10.0.0.2"}, {IP: "10.0.0.1"}, {IP: "10.0.0.2"}}},
+	}}
+	c := &NextTraceResult{Hops: []Hop{
+		{TTL: 1, IP: "10.0.0.1", Responders: []Responder{{IP: "10.0.0.1"}}},
+	}}
+
+	if keys := a.PathKeys(); len(keys) != 1 || keys[0] != "10.0.0.1,10.0.0.2" {
+		t.Errorf("Expected key 10.0.0.1,10.0.0.2, got %v", keys)
+	}
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Errorf("Expected the same responders in another order to keep the fingerprint")
+	}
+	if changed := ChangedTTLs(a, b); len(changed) != 0 {
+		t.Errorf("Expected no changed TTLs, got %v", changed)
+	}
+	if a.Fingerprint() == c.Fingerprint() {
+		t.Errorf("Expected a lost responder to change the fingerprint")
+	}
+	if changed := ChangedTTLs(a, c); len(changed) != 1 || changed[0] != 1 {
+		t.Errorf("Expected TTL 1 to change, got %v", changed)
+	}
+}
+
+func TestSilentHops(t *testing.T) {
+	base := &NextTraceResult{Hops: []Hop{
+		{TTL: 1, IP: "192.168.1.1"},
+		{TTL: 2, IP: "10.0.0.1"},
+		{TTL: 3, IP: "10.0.1.1"},
+	}}
+	silent := &NextTraceResult{Hops: []Hop{
+		{TTL: 1, IP: "192.168.1.1"},
+		{TTL: 2, IP: "*"},
+		{TTL: 3, IP: "10.0.1.1"},
+	}}
+
+	// A mid-path hop that didn't answer matches any key
+	if changed := ChangedTTLs(base, silent); len(changed) != 0 {
+		t.Errorf("Expected no changed TTLs, got %v", changed)
+	}
+	if changed := ChangedTTLs(silent, base); len(changed) != 0 {
+		t.Errorf("Expected no changed TTLs, got %v", changed)
+	}
+
+	merged := MergeSilentHops(base, silent)
+	if merged.Fingerprint() != base.Fingerprint() {
+		t.Errorf("Expected the merged route to keep the fingerprint")
+	}
+	if merged.Hops[1].IP != "10.0.0.1" || merged.Hops[1].TTL != 2 {
+		t.Errorf("Expected hop 2 to be filled from the previous route, got %+v", merged.Hops[1])
+	}
+	if silent.Hops[1].IP != "*" {
+		t.Errorf("Expected the merged result not to modify its input")
+	}
+
+	// A hop answering from elsewhere after a silent run is still a change
+	rerouted := &NextTraceResult{Hops: []Hop{
+		{TTL: 1, IP: "192.168.1.1"},
+		{TTL: 2, IP: "10.0.0.9"},
+		{TTL: 3, IP: "10.0.1.1"},
+	}}
+	if changed := ChangedTTLs(merged, rerouted); len(changed) != 1 || changed[0] != 2 {
+		t.Errorf("Expected TTL 2 to change, got %v", changed)
+	}
+}
+
+func TestHopRTTStatistics(t *testing.T) {
+	hop := Hop{RTT: []float64{4.0, 1.0, 3.0, 2.0, 5.0}}
+
+	tests := []struct {
+		name     string
+		value    float64
+		expected float64
+	}{
+		{"min", hop.MinRTT(), 1.0},
+		{"max", hop.MaxRTT(), 5.0},
+		{"median", hop.MedianRTT(), 3.0},
+		{"p90", hop.PercentileRTT(90), 4.6},
+		{"p0", hop.PercentileRTT(0), 1.0},
+		{"p100", hop.PercentileRTT(100), 5.0},
+		{"stddev", hop.StdDevRTT(), 1.4142},
+	}
+
+	tolerance := 0.001
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.value < tt.expected-tolerance || tt.value > tt.expected+tolerance {
+				t.Errorf("Expected %.4f, got %.4f", tt.expected, tt.value)
+			}
+		})
+	}
+
+	// The original sample order is preserved
+	if hop.RTT[0] != 4.0 {
+		t.Errorf("Expected RTT samples to stay unsorted, got %v", hop.RTT)
+	}
+
+	empty := Hop{}
+	if empty.MinRTT() != 0 || empty.MaxRTT() != 0 || empty.MedianRTT() != 0 || empty.StdDevRTT() != 0 {
+		t.Errorf("Expected zero statistics for hop without RTT samples")
+	}
+
+	single := Hop{RTT: []float64{2.5}}
+	if single.StdDevRTT() != 0 || single.MedianRTT() != 2.5 {
+		t.Errorf("Unexpected statistics for single sample: stddev %.2f, median %.2f", single.StdDevRTT(), single.MedianRTT())
+	}
+}
+
+func TestDestination(t *testing.T) {
+	reached := &NextTraceResult{
+		TargetIP: "8.8.8.8",
+		Hops: []Hop{
+			NewHop([]Probe{{TTL: 1, Success: true, IP: "192.168.1.1", RTT: 1}}),
+			NewHop([]Probe{{TTL: 2}, {TTL: 2}}),
+			NewHop([]Probe{
+				{TTL: 3, Success: true, IP: "8.8.8.8", RTT: 10},
+				{TTL: 3, Success: true, IP: "8.8.8.8", RTT: 20},
+				{TTL: 3, Success: true, IP: "10.0.0.1", RTT: 5},
+				{TTL: 3},
+			}),
+		},
+	}
+	unreached := &NextTraceResult{
+		TargetIP: "8.8.8.8",
+		Hops: []Hop{
+			NewHop([]Probe{{TTL: 1, Success: true, IP: "192.168.1.1", RTT: 1}}),
+			NewHop([]Probe{{TTL: 2}, {TTL: 2}}),
+			NewHop([]Probe{{TTL: 3}, {TTL: 3}}),
+		},
+	}
+	summary := &NextTraceResult{
+		TargetIP: "2001:4860:4860::8888",
+		Hops: []Hop{{
+			TTL:        1,
+			IP:         "2001:4860:4860:0:0:0:0:8888",
+			Responders: []Responder{{IP: "2001:4860:4860:0:0:0:0:8888", Share: 0.9}},
+			Summary:    &RTTSummary{Avg: 12.5},
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		result   *NextTraceResult
+		reached  bool
+		rtt      float64
+		loss     float64
+		trailing int
+	}{
+		{name: "reached", result: reached, reached: true, rtt: 15, loss: 0.5, trailing: 0},
+		{name: "not reached", result: unreached, reached: false, rtt: 0, loss: 1, trailing: 2},
+		{name: "summary only", result: summary, reached: true, rtt: 12.5, loss: 0.1, trailing: 0},
+		{name: "unknown target", result: &NextTraceResult{Hops: reached.Hops}, reached: false, rtt: 0, loss: 1, trailing: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, found := tt.result.Destination(); found != tt.reached {
+				t.Errorf("Expected reached=%v, got %v", tt.reached, found)
+			}
+			if rtt := tt.result.DestinationRTT(); math.Abs(rtt-tt.rtt) > 1e-9 {
+				t.Errorf("Expected destination RTT %v, got %v", tt.rtt, rtt)
+			}
+			if loss := tt.result.DestinationLoss(); math.Abs(loss-tt.loss) > 1e-9 {
+				t.Errorf("Expected destination loss %v, got %v", tt.loss, loss)
+			}
+			if trailing := tt.result.UnresponsiveTrailingHops(); trailing != tt.trailing {
+				t.Errorf("Expected %d unresponsive trailing hops, got %d", tt.trailing, trailing)
+			}
+		})
+	}
+}
+
+func TestSetTargetIP(t *testing.T) {
+	result := &NextTraceResult{
+		Hops: []Hop{NewHop([]Probe{{TTL: 1, Success: true, IP: "142.250.1.2", RTT: 1}})},
+	}
+
+	// The address that answered wins over the order of the lookup
+	result.SetTargetIP([]string{"142.250.1.1", "142.250.1.2"})
+	if result.TargetIP != "142.250.1.2" {
+		t.Errorf("Expected answering address 142.250.1.2, got %s", result.TargetIP)
+	}
+
+	result.SetTargetIP([]string{"142.250.1.3", "142.250.1.4"})
+	if result.TargetIP != "142.250.1.3" {
+		t.Errorf("Expected first address 142.250.1.3, got %s", result.TargetIP)
+	}
+
+	result.SetTargetIP(nil)
+	if result.TargetIP != "" {
+		t.Errorf("Expected empty target IP, got %s", result.TargetIP)
+	}
+}
+
+func TestForwardedLoss(t *testing.T) {
+	tests := []struct {
+		name     string
+		losses   []float64
+		expected []float64
+	}{
+		{name: "no loss", losses: []float64{0, 0, 0}, expected: []float64{0, 0, 0}},
+		{name: "rate limited hop", losses: []float64{0, 0.6, 0}, expected: []float64{0, 0, 0}},
+		{name: "persisting loss", losses: []float64{0, 0.3, 0.5, 0.3}, expected: []float64{0, 0.3, 0.3, 0.3}},
+		{name: "silent hop in the middle", losses: []float64{0, 1, 0.2}, expected: []float64{0, 0.2, 0.2}},
+		{name: "trailing silent hops", losses: []float64{0, 0.5, 1, 1}, expected: []float64{0, 0.5, 1, 1}},
+		{name: "empty", losses: nil, expected: []float64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &NextTraceResult{}
+			for i, loss := range tt.losses {
+				result.Hops = append(result.Hops, Hop{TTL: i + 1, Loss: loss})
+			}
+			forwarded := result.ForwardedLoss()
+			if !reflect.DeepEqual(forwarded, tt.expected) {
+				t.Errorf("Expected forwarded loss %v, got %v", tt.expected, forwarded)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package parser
+
+// Probe is a single probe sent at some TTL, as reported by any trace tool
+type Probe struct {
+	TTL         int
+	Success     bool    // Whether any device answered the probe
+	IP          string  // Address that answered, may be empty for tools that don't report it
+	Hostname    string  // Reverse DNS name of IP, if known
+	RTT         float64 // RTT in milliseconds, 0 if unknown
+	ASN         string
+	Location    string
+	ASName      string
+	CountryCode string
+	Latitude    float64
+	Longitude   float64
+}
+
+// NewHop aggregates the probes sent at one TTL into a hop. Probes are grouped
+// into responders by the address that answered them, and the first responder
+// is reported as the hop itself.
+func NewHop(probes []Probe) Hop {
+	hop := Hop{
+		RTT: make([]float64, 0, len(probes)),
+	}
+
+	successCount := 0
+	responderIndex := make(map[string]int)
+
+	for _, probe := range probes {
+		hop.TTL = probe.TTL
+
+		if !probe.Success {
+			continue
+		}
+		successCount++
+
+		if probe.RTT > 0 {
+			hop.RTT = append(hop.RTT, probe.RTT)
+		}
+
+		if probe.IP == "" {
+			continue
+		}
+
+		// Group probes by the address that answered them
+		idx, exists := responderIndex[probe.IP]
+		if !exists {
+			idx = len(hop.Responders)
+			responderIndex[probe.IP] = idx
+			hop.Responders = append(hop.Responders, Responder{
+				IP:  probe.IP,
+				RTT: make([]float64, 0, len(probes)),
+			})
+		}
+		responder := &hop.Responders[idx]
+		responder.Share++
+		if probe.RTT > 0 {
+			responder.RTT = append(responder.RTT, probe.RTT)
+		}
+
+		// Use the first valid hostname/ASN/location we see for each responder
+		if responder.Hostname == "" {
+			responder.Hostname = probe.Hostname
+		}
+		if responder.ASN == "" {
+			responder.ASN = probe.ASN
+			responder.ASName = probe.ASName
+		}
+		if responder.Location == "" {
+			responder.Location = probe.Location
+			responder.CountryCode = probe.CountryCode
+			responder.Latitude = probe.Latitude
+			responder.Longitude = probe.Longitude
+		}
+	}
+
+	for i := range hop.Responders {
+		hop.Responders[i].Share /= float64(len(probes))
+	}
+
+	if len(hop.Responders) > 0 {
+		primary := hop.Responders[0]
+		hop.IP = primary.IP
+		hop.Hostname = primary.Hostname
+		hop.ASN = primary.ASN
+		hop.Location = primary.Location
+		hop.ASName = primary.ASName
+		hop.CountryCode = primary.CountryCode
+		hop.Latitude = primary.Latitude
+		hop.Longitude = primary.Longitude
+	}
+
+	// Calculate packet loss ratio
+	if len(probes) > 0 {
+		hop.Loss = float64(len(probes)-successCount) / float64(len(probes))
+	}
+
+	return hop
+}
@@ -0,0 +1,170 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NextTraceRawParser parses the output of nexttrace --raw line by line, so
+// hops can be reported while the trace is still running. nexttrace prints
+// one line per probe once all probes of a TTL are done:
+//
+//	traceroute to 8.8.8.8, 30 hops max, 52 bytes payload, ICMP mode
+//	1|192.168.1.1||0.52|||||||0.0000|0.0000
+//	2|*||||||
+//	3|8.8.8.8|dns.google|10.12|15169|United States|California|Mountain View||Google LLC|37.4056|-122.0775
+//
+// The fields are TTL, IP, hostname, RTT in milliseconds, ASN, country,
+// province, city, district, owner, latitude and longitude. Other lines, such
+// as the banner, are ignored.
+type NextTraceRawParser struct {
+	result  NextTraceResult
+	ttl     int     // TTL of the pending probes
+	pending []Probe // Probes of the hop in progress
+	err     error
+}
+
+// NewNextTraceRawParser creates a parser for the output of one trace
+func NewNextTraceRawParser() *NextTraceRawParser {
+	return &NextTraceRawParser{result: NextTraceResult{Hops: []Hop{}}}
+}
+
+// ParseLine consumes a line of output. When the line starts the next TTL,
+// the hop it completes is returned with complete set.
+func (p *NextTraceRawParser) ParseLine(line string) (hop Hop, complete bool) {
+	line = strings.TrimSpace(ansiPattern.ReplaceAllString(line, ""))
+
+	fields := strings.Split(line, "|")
+	ttl, err := strconv.Atoi(fields[0])
+	if err != nil || len(fields) < 2 {
+		if strings.HasPrefix(line, "traceroute to ") {
+			p.parseHeader(line)
+		}
+		return Hop{}, false
+	}
+
+	probe, err := parseRawProbe(ttl, fields[1:])
+	if err != nil {
+		if p.err == nil {
+			p.err = fmt.Errorf("hop %d: %w", ttl, err)
+		}
+		return Hop{}, false
+	}
+
+	if ttl != p.ttl {
+		hop, complete = p.Flush()
+		p.ttl = ttl
+	}
+	p.pending = append(p.pending, probe)
+	return hop, complete
+}
+
+// Flush completes the hop in progress, if any, and returns it
+func (p *NextTraceRawParser) Flush() (hop Hop, complete bool) {
+	if len(p.pending) == 0 {
+		return Hop{}, false
+	}
+
+	hop = NewHop(p.pending)
+	p.pending = nil
+	p.result.Hops = append(p.result.Hops, hop)
+	return hop, true
+}
+
+// Result returns the hops completed so far. It fails when the output had no
+// hops or a malformed hop line.
+func (p *NextTraceRawParser) Result() (*NextTraceResult, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(p.result.Hops) == 0 {
+		return nil, fmt.Errorf("no hops found in nexttrace output")
+	}
+	result := p.result
+	return &result, nil
+}
+
+// parseHeader takes the traced address from the header line, which names it
+// either alone or followed by the address in parentheses
+func (p *NextTraceRawParser) parseHeader(line string) {
+	fields := strings.Fields(strings.TrimPrefix(line, "traceroute to "))
+	if len(fields) == 0 {
+		return
+	}
+
+	p.result.Target = strings.TrimRight(fields[0], ",")
+	candidates := []string{p.result.Target}
+	if len(fields) > 1 {
+		candidates = append([]string{strings.Trim(fields[1], "(),")}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if net.ParseIP(candidate) != nil {
+			p.result.TargetIP = candidate
+			return
+		}
+	}
+}
+
+// parseRawProbe parses the fields of a probe line after the TTL
+func parseRawProbe(ttl int, fields []string) (Probe, error) {
+	probe := Probe{TTL: ttl}
+	if fields[0] == "*" || fields[0] == "" {
+		return probe, nil
+	}
+
+	if net.ParseIP(fields[0]) == nil {
+		return probe, fmt.Errorf("invalid address %q", fields[0])
+	}
+	probe.Success = true
+	probe.IP = fields[0]
+
+	field := func(i int) string {
+		if i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	number := func(i int) (float64, error) {
+		if s := field(i); s != "" {
+			return strconv.ParseFloat(s, 64)
+		}
+		return 0, nil
+	}
+
+	var err error
+	probe.Hostname = field(1)
+	if probe.RTT, err = number(2); err != nil {
+		return probe, fmt.Errorf("invalid RTT %q", field(2))
+	}
+	probe.ASN = field(3)
+	probe.Location = formatLocation(&GeoInfo{CountryEn: field(4), CityEn: field(6)})
+	probe.ASName = field(8)
+	if probe.Latitude, err = number(9); err != nil {
+		return probe, fmt.Errorf("invalid latitude %q", field(9))
+	}
+	if probe.Longitude, err = number(10); err != nil {
+		return probe, fmt.Errorf("invalid longitude %q", field(10))
+	}
+	return probe, nil
+}
+
+// ParseNextTraceRawOutput parses the complete output of nexttrace --raw,
+// see NextTraceRawParser
+func ParseNextTraceRawOutput(data []byte) (*NextTraceResult, error) {
+	p := NewNextTraceRawParser()
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		p.ParseLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read nexttrace output: %w", err)
+	}
+	p.Flush()
+
+	return p.Result()
+}
@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestParseNextTraceRawOutput(t *testing.T) {
+	data := []byte("NextTrace v1.3.7 2024-12-10T07:08:42Z 0e4d2a3\n" +
+		"\x1b[37mtraceroute to\x1b[0m 8.8.8.8, 30 hops max, 52 bytes payload, ICMP mode\n" +
+		"1|192.168.1.1||0.52|||||||0.0000|0.0000\n" +
+		"1|192.168.1.1||0.61|||||||0.0000|0.0000\n" +
+		"1|192.168.1.1||0.48|||||||0.0000|0.0000\n" +
+		"2|*||||||\n" +
+		"2|*||||||\n" +
+		"2|*||||||\n" +
+		"3|10.0.0.1||5.12|4134|China|Shanghai|Shanghai||Chinanet|31.2222|121.4581\n" +
+		"3|10.0.0.2||5.46|4134|China|Shanghai|Shanghai||Chinanet|31.2222|121.4581\n" +
+		"3|*||||||\n" +
+		"4|8.8.8.8|dns.google|10.10|15169|United States|||||37.7510|-97.8220\n")
+
+	result, err := ParseNextTraceRawOutput(data)
+	if err != nil {
+		t.Fatalf("ParseNextTraceRawOutput failed: %v", err)
+	}
+
+	if result.Target != "8.8.8.8" || result.TargetIP != "8.8.8.8" {
+		t.Errorf("Expected target 8.8.8.8, got %s (%s)", result.Target, result.TargetIP)
+	}
+	if len(result.Hops) != 4 {
+		t.Fatalf("Expected 4 hops, got %d", len(result.Hops))
+	}
+
+	first := result.Hops[0]
+	if first.TTL != 1 || first.IP != "192.168.1.1" || len(first.RTT) != 3 || first.Loss != 0 {
+		t.Errorf("Unexpected first hop: ttl=%d ip=%s rtts=%v loss=%v", first.TTL, first.IP, first.RTT, first.Loss)
+	}
+	if first.ASN != "" || first.Location != "" {
+		t.Errorf("Expected no geo data for private hop, got asn=%q location=%q", first.ASN, first.Location)
+	}
+
+	silent := result.Hops[1]
+	if silent.TTL != 2 || silent.HasValidIP() || silent.Loss != 1 {
+		t.Errorf("Expected silent hop 2 with full loss, got ttl=%d ip=%s loss=%v", silent.TTL, silent.IP, silent.Loss)
+	}
+
+	multipath := result.Hops[2]
+	if len(multipath.Responders) != 2 || multipath.Loss < 0.33 || multipath.Loss > 0.34 {
+		t.Errorf("Expected 2 responders and loss 1/3 at hop 3, got %d and %v", len(multipath.Responders), multipath.Loss)
+	}
+	if multipath.ASN != "4134" || multipath.ASName != "Chinanet" || multipath.Location != "Shanghai, China" {
+		t.Errorf("Unexpected geo data at hop 3: asn=%q as_name=%q location=%q", multipath.ASN, multipath.ASName, multipath.Location)
+	}
+	if multipath.Latitude != 31.2222 || multipath.Longitude != 121.4581 {
+		t.Errorf("Expected coordinates 31.2222,121.4581, got %v,%v", multipath.Latitude, multipath.Longitude)
+	}
+
+	dest := result.Hops[3]
+	if dest.IP != "8.8.8.8" || dest.Hostname != "dns.google" || dest.Location != "United States" || dest.AverageRTT() != 10.10 {
+		t.Errorf("Unexpected destination hop: %+v", dest)
+	}
+}
+
+func TestNextTraceRawParserIncremental(t *testing.T) {
+	p := NewNextTraceRawParser()
+
+	steps := []struct {
+		line     string
+		complete int // TTL of the hop completed by the line, 0 if none
+	}{
+		{line: "traceroute to example.com (93.184.216.34), 30 hops max, 52 bytes payload"},
+		{line: "1|192.168.1.1||0.52|||||||0.0000|0.0000"},
+		{line: "1|192.168.1.1||0.61|||||||0.0000|0.0000"},
+		{line: "2|*||||||", complete: 1},
+		{line: "[NextTrace API] preferred API IP"},
+		{line: "3|10.0.0.1||5.12|||||||0.0000|0.0000", complete: 2},
+	}
+	for _, step := range steps {
+		hop, complete := p.ParseLine(step.line)
+		if complete != (step.complete > 0) || hop.TTL != step.complete {
+			t.Errorf("Line %q: expected completed hop %d, got %v (ttl %d)", step.line, step.complete, complete, hop.TTL)
+		}
+	}
+
+	// The hop in progress is only reported on flush
+	if result, err := p.Result(); err != nil || len(result.Hops) != 2 {
+		t.Errorf("Expected 2 completed hops before flush, got %v %v", result, err)
+	}
+	if hop, complete := p.Flush(); !complete || hop.TTL != 3 {
+		t.Errorf("Expected flush to complete hop 3, got %v (ttl %d)", complete, hop.TTL)
+	}
+	if _, complete := p.Flush(); complete {
+		t.Error("Expected nothing left to flush")
+	}
+
+	result, err := p.Result()
+	if err != nil {
+		t.Fatalf("Result failed: %v", err)
+	}
+	if result.Target != "example.com" || result.TargetIP != "93.184.216.34" || len(result.Hops) != 3 {
+		t.Errorf("Unexpected result: target=%s (%s) hops=%d", result.Target, result.TargetIP, len(result.Hops))
+	}
+}
+
+func TestParseNextTraceRawOutputErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "banner only", data: "NextTrace v1.3.7\ntraceroute to 8.8.8.8, 30 hops max\n"},
+		{name: "invalid address", data: "1|not-an-ip||0.52|||||||0.0000|0.0000\n"},
+		{name: "invalid RTT", data: "1|192.168.1.1||fast|||||||0.0000|0.0000\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseNextTraceRawOutput([]byte(tt.data)); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ParseTracerouteOutput parses the text output of the classic traceroute
+// command, with or without -n:
+//
+//	traceroute to 8.8.8.8 (8.8.8.8), 30 hops max, 60 byte packets
+//	 1  192.168.1.1  0.512 ms  0.470 ms  0.452 ms
+//	 2  * * *
+//	 3  10.0.0.1  5.123 ms 10.0.0.2  5.456 ms  5.789 ms
+//	 4  dns.google (8.8.8.8)  10.1 ms  10.2 ms  10.3 ms
+//
+// Every RTT and every "*" is one probe, answered by the last address printed
+// before it. Annotations such as !H are ignored.
+func ParseTracerouteOutput(data []byte) (*NextTraceResult, error) {
+	result := &NextTraceResult{Hops: []Hop{}}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		ttl, err := strconv.Atoi(fields[0])
+		if err != nil {
+			// The header names the destination, anything else is a warning
+			if strings.HasPrefix(line, "traceroute to ") && len(fields) > 3 {
+				result.Target = fields[2]
+				result.TargetIP = strings.Trim(fields[3], "(),")
+			}
+			continue
+		}
+
+		probes, err := parseTracerouteProbes(ttl, fields[1:])
+		if err != nil {
+			return nil, fmt.Errorf("hop %d: %w", ttl, err)
+		}
+		result.Hops = append(result.Hops, NewHop(probes))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read traceroute output: %w", err)
+	}
+
+	if len(result.Hops) == 0 {
+		return nil, fmt.Errorf("no hops found in traceroute output")
+	}
+	return result, nil
+}
+
+// parseTracerouteProbes parses the fields of a hop line after the TTL
+func parseTracerouteProbes(ttl int, fields []string) ([]Probe, error) {
+	var (
+		probes   []Probe
+		ip       string
+		hostname string
+	)
+
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		switch {
+		case field == "*":
+			probes = append(probes, Probe{TTL: ttl})
+		case strings.HasPrefix(field, "!"):
+			// Annotation of the previous probe, e.g. !H, !N or !X
+		case i+1 < len(fields) && fields[i+1] == "ms":
+			rtt, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RTT %q", field)
+			}
+			if ip == "" {
+				return nil, fmt.Errorf("RTT %q without address", field)
+			}
+			probes = append(probes, Probe{TTL: ttl, Success: true, IP: ip, Hostname: hostname, RTT: rtt})
+			i++ // Skip the unit
+		case i+1 < len(fields) && strings.HasPrefix(fields[i+1], "(") && strings.HasSuffix(fields[i+1], ")"):
+			// "hostname (address)" when names are resolved
+			hostname, ip = splitHostAddress(field + " " + fields[i+1])
+			i++
+		case net.ParseIP(field) != nil:
+			ip, hostname = field, ""
+		default:
+			return nil, fmt.Errorf("unexpected field %q", field)
+		}
+	}
+
+	if len(probes) == 0 {
+		return nil, fmt.Errorf("no probes found")
+	}
+	return probes, nil
+}
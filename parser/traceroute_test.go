@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestParseTracerouteOutput(t *testing.T) {
+	data := []byte(`traceroute to dns.google (8.8.8.8), 30 hops max, 60 byte packets
+ 1  192.168.1.1  0.512 ms  0.470 ms  0.452 ms
+ 2  * * *
+ 3  10.0.0.1  5.123 ms 10.0.0.2  5.456 ms *
+ 4  dns.google (8.8.8.8)  10.100 ms !H  10.200 ms  10.300 ms
+`)
+
+	result, err := ParseTracerouteOutput(data)
+	if err != nil {
+		t.Fatalf("ParseTracerouteOutput failed: %v", err)
+	}
+
+	if result.Target != "dns.google" || result.TargetIP != "8.8.8.8" {
+		t.Errorf("Expected target dns.google (8.8.8.8), got %s (%s)", result.Target, result.TargetIP)
+	}
+	if len(result.Hops) != 4 {
+		t.Fatalf("Expected 4 hops, got %d", len(result.Hops))
+	}
+
+	first := result.Hops[0]
+	if first.IP != "192.168.1.1" || len(first.RTT) != 3 || first.Loss != 0 {
+		t.Errorf("Unexpected first hop: ip=%s rtts=%v loss=%v", first.IP, first.RTT, first.Loss)
+	}
+
+	silent := result.Hops[1]
+	if silent.TTL != 2 || silent.HasValidIP() || silent.Loss != 1 {
+		t.Errorf("Expected silent hop 2 with full loss, got ttl=%d ip=%s loss=%v", silent.TTL, silent.IP, silent.Loss)
+	}
+
+	multipath := result.Hops[2]
+	if len(multipath.Responders) != 2 {
+		t.Fatalf("Expected 2 responders at hop 3, got %d", len(multipath.Responders))
+	}
+	if multipath.Responders[1].IP != "10.0.0.2" || len(multipath.Responders[1].RTT) != 1 {
+		t.Errorf("Expected second responder 10.0.0.2 with one RTT, got %+v", multipath.Responders[1])
+	}
+	if multipath.Loss < 0.33 || multipath.Loss > 0.34 {
+		t.Errorf("Expected loss 1/3, got %v", multipath.Loss)
+	}
+
+	dest := result.Hops[3]
+	if dest.IP != "8.8.8.8" || dest.Hostname != "dns.google" {
+		t.Errorf("Expected dns.google (8.8.8.8), got %s (%s)", dest.Hostname, dest.IP)
+	}
+	if len(dest.RTT) != 3 {
+		t.Errorf("Expected annotations to be skipped, got RTTs %v", dest.RTT)
+	}
+}
+
+func TestParseTracerouteOutputErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "error message", data: "traceroute: unknown host example.invalid\n"},
+		{name: "garbage in hop", data: "traceroute to 8.8.8.8 (8.8.8.8), 30 hops max\n 1  what is this\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTracerouteOutput([]byte(tt.data)); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package tracer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// LineParser parses the output of a trace tool line by line while it runs
+type LineParser interface {
+	// ParseLine consumes a line and returns the hop it completed, if any
+	ParseLine(line string) (hop parser.Hop, complete bool)
+	// Flush completes the hop in progress at the end of the output
+	Flush() (hop parser.Hop, complete bool)
+	// Result returns the trace made of the hops completed so far
+	Result() (*parser.NextTraceResult, error)
+}
+
+// waitDelay bounds how long a cancelled tool may keep its output open after
+// it was killed, e.g. through a helper that left its process group
+const waitDelay = 5 * time.Second
+
+// Command runs traces with an external tool and parses its output
+type Command struct {
+	// BinaryPath is the tool to run, unless the target sets its own binary
+	BinaryPath string
+	// Args builds the command line arguments for a target
+	Args func(target config.Target) []string
+	// Parse converts the standard output of the tool
+	Parse func(output []byte) (*parser.NextTraceResult, error)
+	// Stream, if set, creates a parser reading the output while the tool
+	// runs, which reports hops as they complete and keeps them if the tool
+	// fails or times out. Parse is not used then.
+	Stream func() LineParser
+	// MaxOutput is the number of bytes kept of the standard output and of
+	// the standard error of the tool each, DefaultMaxOutput if not positive.
+	// The start of the standard output is kept for parsing and the end of
+	// the standard error for error messages.
+	MaxOutput int
+}
+
+// newOutput returns the buffers capturing the output of a run
+func (c *Command) newOutput() (*headBuffer, *tailBuffer) {
+	limit := c.MaxOutput
+	if limit <= 0 {
+		limit = DefaultMaxOutput
+	}
+	return &headBuffer{max: limit}, &tailBuffer{max: limit}
+}
+
+// Trace implements Tracer
+func (c *Command) Trace(ctx context.Context, target config.Target, onHop HopFunc) (*parser.NextTraceResult, error) {
+	binary := c.BinaryPath
+	if target.Binary != "" {
+		binary = target.Binary
+	}
+
+	cmd := exec.CommandContext(ctx, binary, c.Args(target)...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	if c.Stream != nil {
+		return c.stream(cmd, onHop)
+	}
+
+	stdout, stderr := c.newOutput()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, &CommandError{Err: err, Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	}
+
+	// A truncated report would fail to parse in confusing ways
+	if stdout.truncated {
+		return nil, &ParseError{
+			Err:    fmt.Errorf("output exceeds %d bytes", stdout.max),
+			Stdout: stdout.Bytes(),
+			Stderr: stderr.Bytes(),
+		}
+	}
+
+	result, err := c.Parse(stdout.Bytes())
+	if err != nil {
+		return nil, &ParseError{Err: err, Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	}
+	return result, nil
+}
+
+// stream runs cmd and parses its standard output line by line, passing hops
+// to onHop as they complete
+func (c *Command) stream(cmd *exec.Cmd, onHop HopFunc) (*parser.NextTraceResult, error) {
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, &CommandError{Err: err}
+	}
+	stdout, stderr := c.newOutput()
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, &CommandError{Err: err}
+	}
+
+	lines := c.Stream()
+	report := func(hop parser.Hop, complete bool) {
+		if complete && onHop != nil {
+			onHop(hop)
+		}
+	}
+
+	// Lines are parsed as they come, so only the copy of the output kept
+	// for error reports is capped
+	reader := io.TeeReader(stdoutPipe, stdout)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		report(lines.ParseLine(scanner.Text()))
+	}
+	// Keep reading past an overlong line so the tool doesn't block
+	_, _ = io.Copy(io.Discard, reader)
+
+	err = cmd.Wait()
+	report(lines.Flush())
+	result, parseErr := lines.Result()
+
+	if err != nil {
+		cmdErr := &CommandError{Err: err, Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+		if parseErr == nil {
+			return result, cmdErr
+		}
+		return nil, cmdErr
+	}
+	if parseErr != nil {
+		return nil, &ParseError{Err: parseErr, Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	}
+	return result, nil
+}
@@ -0,0 +1,129 @@
+package tracer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// writeScript writes an executable shell script and returns its path
+func writeScript(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trace.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCommandBinaryOverride(t *testing.T) {
+	script := writeScript(t, `echo "traceroute to $last (1.1.1.1), 30 hops max"
+echo " 1  192.168.1.1  0.5 ms  0.6 ms"
+`)
+
+	// The default binary doesn't exist, so only the target's binary can succeed
+	tr := NewTraceroute(filepath.Join(t.TempDir(), "missing"))
+	result, err := tr.Trace(context.Background(), config.Target{Host: "1.1.1.1", Binary: script}, nil)
+	if err != nil {
+		t.Fatalf("Trace failed: %v", err)
+	}
+	if len(result.Hops) != 1 || result.Hops[0].IP != "192.168.1.1" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	var cmdErr *CommandError
+	if _, err := tr.Trace(context.Background(), config.Target{Host: "1.1.1.1"}, nil); !errors.As(err, &cmdErr) {
+		t.Errorf("Expected CommandError for missing binary, got %v", err)
+	}
+}
+
+func TestCommandParseError(t *testing.T) {
+	script := writeScript(t, "echo 'not a report'\n")
+
+	var parseErr *ParseError
+	_, err := NewMTR(script).Trace(context.Background(), config.Target{Host: "1.1.1.1"}, nil)
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected ParseError, got %v", err)
+	}
+	if string(parseErr.Stdout) != "not a report\n" {
+		t.Errorf("Expected output to be kept, got %q", parseErr.Stdout)
+	}
+}
+
+func TestCommandOutputStreams(t *testing.T) {
+	script := writeScript(t, `echo "warning: slow DNS" >&2
+echo "traceroute to 1.1.1.1 (1.1.1.1), 30 hops max"
+echo " 1  192.168.1.1  0.5 ms  0.6 ms"
+echo "done" >&2
+`)
+
+	// Warnings on stderr don't reach the parser
+	if _, err := NewTraceroute(script).Trace(context.Background(), config.Target{Host: "1.1.1.1"}, nil); err != nil {
+		t.Fatalf("Trace failed: %v", err)
+	}
+
+	// Output beyond the limit fails to parse instead of being cut silently,
+	// and only the end of stderr is kept
+	tr := NewTraceroute(script)
+	tr.MaxOutput = 16
+	var parseErr *ParseError
+	if _, err := tr.Trace(context.Background(), config.Target{Host: "1.1.1.1"}, nil); !errors.As(err, &parseErr) {
+		t.Fatalf("Expected ParseError for oversized output, got %v", err)
+	}
+	if len(parseErr.Stdout) != 16 || string(parseErr.Stderr) != ": slow DNS\ndone\n" {
+		t.Errorf("Unexpected output kept: stdout=%q stderr=%q", parseErr.Stdout, parseErr.Stderr)
+	}
+
+	failing := writeScript(t, `echo "traceroute to 1.1.1.1, 30 hops max"
+echo "socket: Operation not permitted" >&2
+exit 1
+`)
+	var cmdErr *CommandError
+	if _, err := NewNextTrace(failing).Trace(context.Background(), config.Target{Host: "1.1.1.1"}, nil); !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected CommandError, got %v", err)
+	}
+	if string(cmdErr.Stdout) != "traceroute to 1.1.1.1, 30 hops max\n" || string(cmdErr.Stderr) != "socket: Operation not permitted\n" {
+		t.Errorf("Expected separate stdout and stderr, got stdout=%q stderr=%q", cmdErr.Stdout, cmdErr.Stderr)
+	}
+}
+
+func TestCommandStream(t *testing.T) {
+	script := writeScript(t, `echo "traceroute to 8.8.8.8, 30 hops max, 52 bytes payload"
+echo "1|192.168.1.1||0.52|||||||0.0000|0.0000"
+echo "1|192.168.1.1||0.61|||||||0.0000|0.0000"
+echo "2|*||||||"
+echo "2|10.0.0.1||5.10|64512||||||0.0000|0.0000"
+exec sleep 10
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	var streamed []parser.Hop
+	result, err := NewNextTrace(script).Trace(ctx, config.Target{Host: "8.8.8.8"}, func(hop parser.Hop) {
+		streamed = append(streamed, hop)
+	})
+
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected CommandError after timeout, got %v", err)
+	}
+	if result == nil || len(result.Hops) != 2 {
+		t.Fatalf("Expected the 2 completed hops, got %+v", result)
+	}
+	if result.TargetIP != "8.8.8.8" || result.Hops[1].Loss != 0.5 || result.Hops[1].ASN != "64512" {
+		t.Errorf("Unexpected partial result: %+v", result)
+	}
+	if len(streamed) != 2 || streamed[0].TTL != 1 || streamed[1].TTL != 2 {
+		t.Errorf("Expected hops 1 and 2 to be streamed, got %+v", streamed)
+	}
+	if len(cmdErr.Stdout) == 0 {
+		t.Error("Expected output to be kept")
+	}
+}
@@ -0,0 +1,44 @@
+package tracer
+
+import (
+	"strconv"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// NewMTR creates a tracer running the mtr binary at binaryPath
+func NewMTR(binaryPath string) *Command {
+	return &Command{
+		BinaryPath: binaryPath,
+		Args:       mtrArgs,
+		Parse:      parser.ParseMTROutput,
+	}
+}
+
+// mtrArgs builds the mtr command line for a target
+func mtrArgs(target config.Target) []string {
+	// --json for a JSON report, -b to print both hostnames and addresses
+	args := []string{"--json", "-b"}
+
+	switch target.Protocol {
+	case config.ProtocolTCP:
+		args = append(args, "--tcp")
+	case config.ProtocolUDP:
+		args = append(args, "--udp")
+	}
+	if target.Port > 0 {
+		args = append(args, "--port", strconv.Itoa(target.Port))
+	}
+
+	// mtr sends one probe per hop and cycle
+	if target.Queries > 0 {
+		args = append(args, "-c", strconv.Itoa(target.Queries))
+	}
+
+	if target.MaxHops > 0 {
+		args = append(args, "-m", strconv.Itoa(target.MaxHops))
+	}
+
+	return append(args, target.Host)
+}
@@ -0,0 +1,36 @@
+package tracer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+func TestMTRArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   config.Target
+		expected []string
+	}{
+		{
+			name:     "icmp default",
+			target:   config.Target{Host: "8.8.8.8", MaxHops: 30, Protocol: config.ProtocolICMP},
+			expected: []string{"--json", "-b", "-m", "30", "8.8.8.8"},
+		},
+		{
+			name:     "tcp with port and queries",
+			target:   config.Target{Host: "example.com", MaxHops: 20, Protocol: config.ProtocolTCP, Port: 443, Queries: 5},
+			expected: []string{"--json", "-b", "--tcp", "--port", "443", "-c", "5", "-m", "20", "example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := mtrArgs(tt.target)
+			if !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("mtrArgs() = %v, want %v", args, tt.expected)
+			}
+		})
+	}
+}
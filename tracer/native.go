@@ -0,0 +1,277 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// Native is a built-in traceroute engine that needs no external binary. It
+// sends ICMP echo or UDP probes with increasing TTLs and matches the replies
+// they trigger. It uses unprivileged sockets where the kernel allows them and
+// falls back to raw sockets otherwise.
+type Native struct {
+	// Interval is the delay between two probes
+	Interval time.Duration
+	// Wait is how long to wait for outstanding replies after the last probe
+	Wait time.Duration
+	// ResolveHostnames enables reverse DNS lookups of the hop addresses
+	ResolveHostnames bool
+}
+
+// Defaults for the native engine
+const (
+	DefaultNativeQueries  = 3
+	DefaultNativeInterval = 10 * time.Millisecond
+	DefaultNativeWait     = 2 * time.Second
+
+	// baseUDPPort is the first destination port of UDP probes when the target
+	// has no port, each probe using the next one like classic traceroute
+	baseUDPPort = 33434
+
+	// lookupTimeout bounds the reverse DNS lookups of a trace
+	lookupTimeout = 2 * time.Second
+)
+
+// reply is a response matched to one of the probes sent by a prober
+type reply struct {
+	index int       // Index of the probe that triggered the reply
+	from  net.IP    // Address that answered
+	at    time.Time // When the reply was received
+	final bool      // Whether the reply ends the trace (destination reached or unreachable)
+}
+
+// prober sends probes and receives the replies they trigger. Probes are
+// identified by their index, which the prober encodes into the packets.
+type prober interface {
+	send(index, ttl int) error
+	receive(timeout time.Duration) ([]reply, error)
+	close() error
+}
+
+// sentProbe is the state of a probe during a trace
+type sentProbe struct {
+	ttl    int
+	sentAt time.Time
+	reply  *reply
+}
+
+// NewNative creates a native tracer with the default settings
+func NewNative() *Native {
+	return &Native{
+		Interval:         DefaultNativeInterval,
+		Wait:             DefaultNativeWait,
+		ResolveHostnames: true,
+	}
+}
+
+// Trace implements Tracer. Hops are only known once all probes are done, so
+// onHop is not used.
+func (n *Native) Trace(ctx context.Context, target config.Target, onHop HopFunc) (*parser.NextTraceResult, error) {
+	dst, err := resolveHost(ctx, target.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	maxHops := target.MaxHops
+	if maxHops == 0 {
+		maxHops = 30
+	}
+	queries := target.Queries
+	if queries == 0 {
+		queries = DefaultNativeQueries
+	}
+
+	p, err := openProber(dst, target.Protocol, target.Port)
+	if err != nil {
+		return nil, err
+	}
+	defer p.close()
+
+	probes, destTTL, err := n.probe(ctx, p, maxHops, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	lastTTL := maxHops
+	if destTTL > 0 {
+		lastTTL = destTTL
+	}
+
+	hostnames := make(map[string]string)
+	if n.ResolveHostnames {
+		hostnames = lookupHostnames(ctx, probes)
+	}
+
+	byTTL := make([][]parser.Probe, lastTTL)
+	for _, probe := range probes {
+		if probe.ttl > lastTTL {
+			continue
+		}
+		converted := parser.Probe{TTL: probe.ttl}
+		if probe.reply != nil {
+			converted.Success = true
+			converted.IP = probe.reply.from.String()
+			converted.Hostname = hostnames[converted.IP]
+			converted.RTT = float64(probe.reply.at.Sub(probe.sentAt)) / float64(time.Millisecond)
+		}
+		byTTL[probe.ttl-1] = append(byTTL[probe.ttl-1], converted)
+	}
+
+	result := &parser.NextTraceResult{
+		Target:   target.Host,
+		TargetIP: dst.String(),
+		Hops:     make([]parser.Hop, 0, lastTTL),
+	}
+	for _, ttlProbes := range byTTL {
+		if len(ttlProbes) > 0 {
+			result.Hops = append(result.Hops, parser.NewHop(ttlProbes))
+		}
+	}
+	return result, nil
+}
+
+// probe sends queries rounds of probes, one per TTL up to maxHops, and
+// collects their replies. It returns the probes and the lowest TTL that
+// ended the trace, or 0 if the destination was never reached.
+func (n *Native) probe(ctx context.Context, p prober, maxHops, queries int) ([]sentProbe, int, error) {
+	probes := make([]sentProbe, 0, maxHops*queries)
+	destTTL := 0
+
+	collect := func(until time.Time, stopWhenDone bool) error {
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			remaining := time.Until(until)
+			if remaining <= 0 {
+				return nil
+			}
+			// Wake up regularly to notice cancellation
+			if remaining > 100*time.Millisecond {
+				remaining = 100 * time.Millisecond
+			}
+
+			replies, err := p.receive(remaining)
+			if err != nil {
+				return err
+			}
+			for i := range replies {
+				r := replies[i]
+				if r.index < 0 || r.index >= len(probes) || probes[r.index].reply != nil {
+					continue
+				}
+				probes[r.index].reply = &r
+				if r.final && (destTTL == 0 || probes[r.index].ttl < destTTL) {
+					destTTL = probes[r.index].ttl
+				}
+			}
+
+			if stopWhenDone && allAnswered(probes, destTTL) {
+				return nil
+			}
+		}
+	}
+
+	for q := 0; q < queries; q++ {
+		for ttl := 1; ttl <= maxHops; ttl++ {
+			// Probing past the destination only produces duplicate replies
+			if destTTL > 0 && ttl > destTTL {
+				break
+			}
+
+			// Take the send time first, loopback replies can arrive within send
+			index := len(probes)
+			probes = append(probes, sentProbe{ttl: ttl, sentAt: time.Now()})
+			if err := p.send(index, ttl); err != nil {
+				return nil, 0, fmt.Errorf("failed to send probe: %w", err)
+			}
+
+			if err := collect(time.Now().Add(n.Interval), false); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	if err := collect(time.Now().Add(n.Wait), true); err != nil {
+		return nil, 0, err
+	}
+	return probes, destTTL, nil
+}
+
+// allAnswered reports whether every probe up to the destination got a reply
+func allAnswered(probes []sentProbe, destTTL int) bool {
+	for _, probe := range probes {
+		if probe.reply == nil && (destTTL == 0 || probe.ttl <= destTTL) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveHost returns the address to trace for host, preferring IPv4 like
+// nexttrace does
+func resolveHost(ctx context.Context, host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("failed to resolve %s: no addresses", host)
+	}
+	return addrs[0].IP, nil
+}
+
+// lookupHostnames resolves the reverse DNS names of all addresses that
+// answered, concurrently and within lookupTimeout
+func lookupHostnames(ctx context.Context, probes []sentProbe) map[string]string {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	seen := make(map[string]bool)
+	var ips []string
+	for _, probe := range probes {
+		if probe.reply != nil && !seen[probe.reply.from.String()] {
+			seen[probe.reply.from.String()] = true
+			ips = append(ips, probe.reply.from.String())
+		}
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		hostnames = make(map[string]string)
+	)
+	for _, ip := range ips {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+			if err != nil || len(names) == 0 {
+				return
+			}
+			mu.Lock()
+			hostnames[ip] = strings.TrimSuffix(names[0], ".")
+			mu.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+
+	return hostnames
+}
@@ -0,0 +1,510 @@
+package tracer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+	"unsafe"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"golang.org/x/sys/unix"
+)
+
+// ICMP message types used by the prober
+const (
+	icmpv4EchoReply    = 0
+	icmpv4Unreachable  = 3
+	icmpv4EchoRequest  = 8
+	icmpv4TimeExceeded = 11
+
+	icmpv6Unreachable  = 1
+	icmpv6TimeExceeded = 3
+	icmpv6EchoRequest  = 128
+	icmpv6EchoReply    = 129
+)
+
+// sizeofSockExtendedErr is the size of struct sock_extended_err, which is
+// followed by the address of the ICMP error sender
+const sizeofSockExtendedErr = int(unsafe.Sizeof(unix.SockExtendedErr{}))
+
+// linuxProber sends probes from a single socket. ICMP errors triggered by the
+// probes are read from the socket error queue (IP_RECVERR), which works on
+// unprivileged ICMP and UDP sockets alike. Raw sockets are only used for ICMP
+// when unprivileged ICMP sockets are disabled (net.ipv4.ping_group_range).
+//
+// UDP probes to a fixed port are sent from a socket each, like traceroute -U
+// does: routers may quote only 8 bytes of the probe after its IP header, and
+// the kernel hands out the quote past the UDP header, so the probe is told
+// apart by the source port of the socket the error is queued on.
+type linuxProber struct {
+	fd       int         // Socket of all probes, -1 with per-probe sockets
+	sockets  map[int]int // Per-probe sockets to the index of their probe
+	family   int
+	dst      net.IP
+	ipv6     bool
+	protocol string
+	port     int    // Fixed UDP destination port, 0 for one port per probe
+	raw      bool   // Raw ICMP socket, which sees all ICMP traffic of the host
+	id       uint16 // ICMP echo identifier, only checked on raw sockets
+	buf      []byte
+	oob      []byte
+}
+
+// openProber opens a socket for probing dst
+func openProber(dst net.IP, protocol string, port int) (prober, error) {
+	p := &linuxProber{
+		fd:       -1,
+		dst:      dst,
+		ipv6:     dst.To4() == nil,
+		protocol: protocol,
+		port:     port,
+		id:       uint16(os.Getpid()),
+		buf:      make([]byte, 1500),
+		oob:      make([]byte, 512),
+	}
+
+	family, icmpProto := unix.AF_INET, unix.IPPROTO_ICMP
+	if p.ipv6 {
+		family, icmpProto = unix.AF_INET6, unix.IPPROTO_ICMPV6
+	} else {
+		p.dst = dst.To4()
+	}
+	p.family = family
+
+	var err error
+	switch protocol {
+	case config.ProtocolUDP:
+		if port != 0 {
+			// Sockets are opened by send
+			p.sockets = make(map[int]int)
+			return p, nil
+		}
+		p.fd, err = socket(family, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	case config.ProtocolICMP, "":
+		p.fd, err = socket(family, unix.SOCK_DGRAM, icmpProto)
+		if errors.Is(err, unix.EACCES) || errors.Is(err, unix.EPERM) {
+			p.raw = true
+			p.fd, err = socket(family, unix.SOCK_RAW, icmpProto)
+		}
+	default:
+		return nil, fmt.Errorf("protocol %q is not supported by the native backend", protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open socket: %w", err)
+	}
+	if err := p.configure(p.fd); err != nil {
+		unix.Close(p.fd)
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// configure enables the error queue and receive timestamps on a socket
+func (p *linuxProber) configure(fd int) error {
+	level, opt := unix.SOL_IP, unix.IP_RECVERR
+	if p.ipv6 {
+		level, opt = unix.SOL_IPV6, unix.IPV6_RECVERR
+	}
+	if err := unix.SetsockoptInt(fd, level, opt, 1); err != nil {
+		return fmt.Errorf("failed to enable error queue: %w", err)
+	}
+	// Kernel receive timestamps make RTTs independent of how quickly we poll
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1); err != nil {
+		return fmt.Errorf("failed to enable timestamps: %w", err)
+	}
+	return nil
+}
+
+// socket opens a non-blocking socket
+func socket(family, typ, proto int) (int, error) {
+	return unix.Socket(family, typ|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, proto)
+}
+
+func (p *linuxProber) close() error {
+	var err error
+	if p.fd >= 0 {
+		err = unix.Close(p.fd)
+	}
+	for fd := range p.sockets {
+		unix.Close(fd)
+	}
+	return err
+}
+
+// send sends probe index with the given TTL
+func (p *linuxProber) send(index, ttl int) error {
+	fd := p.fd
+	if p.sockets != nil {
+		var err error
+		if fd, err = socket(p.family, unix.SOCK_DGRAM, unix.IPPROTO_UDP); err != nil {
+			return fmt.Errorf("failed to open socket: %w", err)
+		}
+		if err := p.configure(fd); err != nil {
+			unix.Close(fd)
+			return err
+		}
+		p.sockets[fd] = index
+	}
+
+	level, opt := unix.SOL_IP, unix.IP_TTL
+	if p.ipv6 {
+		level, opt = unix.SOL_IPV6, unix.IPV6_UNICAST_HOPS
+	}
+	if err := unix.SetsockoptInt(fd, level, opt, ttl); err != nil {
+		return err
+	}
+
+	var packet []byte
+	port := 0
+	if p.protocol == config.ProtocolUDP {
+		packet = make([]byte, 16)
+		port = p.port
+		if port == 0 {
+			port = baseUDPPort + index
+		}
+	} else {
+		packet = p.echoRequest(uint16(index))
+	}
+
+	var to unix.Sockaddr
+	if p.ipv6 {
+		addr := &unix.SockaddrInet6{Port: port}
+		copy(addr.Addr[:], p.dst)
+		to = addr
+	} else {
+		addr := &unix.SockaddrInet4{Port: port}
+		copy(addr.Addr[:], p.dst)
+		to = addr
+	}
+
+	// An ICMP error queued for an earlier probe can be reported by the next
+	// send, which then has to be retried
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		err = unix.Sendto(fd, packet, 0, to)
+		if !isICMPErrno(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// echoRequest builds an ICMP echo request carrying seq
+func (p *linuxProber) echoRequest(seq uint16) []byte {
+	packet := make([]byte, 16)
+	packet[0] = icmpv4EchoRequest
+	if p.ipv6 {
+		packet[0] = icmpv6EchoRequest
+	}
+	// Unprivileged sockets replace the identifier with their own
+	binary.BigEndian.PutUint16(packet[4:], p.id)
+	binary.BigEndian.PutUint16(packet[6:], seq)
+	// The kernel computes ICMPv6 checksums, ICMPv4 ones are ours to fill in
+	if !p.ipv6 {
+		binary.BigEndian.PutUint16(packet[2:], checksum(packet))
+	}
+	return packet
+}
+
+// receive waits up to timeout for replies and returns all that are pending
+func (p *linuxProber) receive(timeout time.Duration) ([]reply, error) {
+	var fds []unix.PollFd
+	if p.fd >= 0 {
+		fds = append(fds, unix.PollFd{Fd: int32(p.fd), Events: unix.POLLIN})
+	}
+	for fd := range p.sockets {
+		fds = append(fds, unix.PollFd{Fd: int32(fd), Events: unix.POLLIN})
+	}
+	if _, err := unix.Poll(fds, int(timeout.Milliseconds())); err != nil && err != unix.EINTR {
+		return nil, err
+	}
+
+	var replies []reply
+	for _, pfd := range fds {
+		fd := int(pfd.Fd)
+		answered := false
+		for {
+			r, ok, err := p.readErrorQueue(fd)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			if r != nil {
+				replies = append(replies, *r)
+				answered = true
+			}
+		}
+		for {
+			r, ok, err := p.read(fd)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			if r != nil {
+				replies = append(replies, *r)
+				answered = true
+			}
+		}
+
+		// A per-probe socket is done once its probe got a reply
+		if _, ok := p.sockets[fd]; ok && answered {
+			unix.Close(fd)
+			delete(p.sockets, fd)
+		}
+	}
+	return replies, nil
+}
+
+// readErrorQueue reads one ICMP error from the error queue of socket fd. It
+// returns ok=false once the queue is empty, and a nil reply for messages
+// that don't belong to a probe.
+func (p *linuxProber) readErrorQueue(fd int) (*reply, bool, error) {
+	n, oobn, _, from, err := unix.Recvmsg(fd, p.buf, p.oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read error queue: %w", err)
+	}
+
+	cmsgs, err := unix.ParseSocketControlMessage(p.oob[:oobn])
+	if err != nil {
+		return nil, true, nil
+	}
+
+	var (
+		ee       *unix.SockExtendedErr
+		offender net.IP
+		at       = time.Now()
+	)
+	for _, cmsg := range cmsgs {
+		switch {
+		case cmsg.Header.Level == unix.SOL_SOCKET && cmsg.Header.Type == unix.SCM_TIMESTAMPNS:
+			at = parseTimestamp(cmsg.Data, at)
+		case (cmsg.Header.Level == unix.SOL_IP && cmsg.Header.Type == unix.IP_RECVERR) ||
+			(cmsg.Header.Level == unix.SOL_IPV6 && cmsg.Header.Type == unix.IPV6_RECVERR):
+			if len(cmsg.Data) < sizeofSockExtendedErr {
+				continue
+			}
+			ee = (*unix.SockExtendedErr)(unsafe.Pointer(&cmsg.Data[0]))
+			offender = parseOffender(cmsg.Data[sizeofSockExtendedErr:])
+		}
+	}
+	if ee == nil || offender == nil ||
+		(ee.Origin != unix.SO_EE_ORIGIN_ICMP && ee.Origin != unix.SO_EE_ORIGIN_ICMP6) {
+		return nil, true, nil
+	}
+
+	index := p.errorIndex(fd, from, p.buf[:n])
+	if index < 0 {
+		return nil, true, nil
+	}
+
+	timeExceeded := (ee.Origin == unix.SO_EE_ORIGIN_ICMP && ee.Type == icmpv4TimeExceeded) ||
+		(ee.Origin == unix.SO_EE_ORIGIN_ICMP6 && ee.Type == icmpv6TimeExceeded)
+	unreachable := (ee.Origin == unix.SO_EE_ORIGIN_ICMP && ee.Type == icmpv4Unreachable) ||
+		(ee.Origin == unix.SO_EE_ORIGIN_ICMP6 && ee.Type == icmpv6Unreachable)
+	if !timeExceeded && !unreachable {
+		return nil, true, nil
+	}
+
+	// Any unreachable error ends the trace, like !H and !N in traceroute;
+	// port unreachable from the destination is how UDP probes arrive
+	return &reply{index: index, from: offender, at: at, final: unreachable}, true, nil
+}
+
+// errorIndex returns the index of the probe an ICMP error queued on socket
+// fd was sent for, or -1. from is the original destination of the probe and
+// payload the quoted probe, starting at its ICMP header or past its UDP
+// header, which leaves nothing when routers quote only 8 bytes.
+func (p *linuxProber) errorIndex(fd int, from unix.Sockaddr, payload []byte) int {
+	if p.protocol == config.ProtocolUDP {
+		if p.sockets != nil {
+			if index, ok := p.sockets[fd]; ok {
+				return index
+			}
+			return -1
+		}
+		return sockaddrPort(from) - baseUDPPort
+	}
+
+	// The 8 bytes every router quotes cover the echo request header
+	if len(payload) < 8 || (p.raw && binary.BigEndian.Uint16(payload[4:]) != p.id) {
+		return -1
+	}
+	return int(binary.BigEndian.Uint16(payload[6:]))
+}
+
+// read reads one packet from socket fd. On raw sockets this includes the
+// ICMP errors the host receives, which are matched against the probes too.
+func (p *linuxProber) read(fd int) (*reply, bool, error) {
+	n, oobn, _, from, err := unix.Recvmsg(fd, p.buf, p.oob, unix.MSG_DONTWAIT)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return nil, false, nil
+	}
+	if isICMPErrno(err) {
+		// Pending error of a queued ICMP error, the details are in the error queue
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read socket: %w", err)
+	}
+	if p.protocol == config.ProtocolUDP {
+		// A UDP answer from the destination can't be matched to a probe
+		return nil, true, nil
+	}
+
+	at := time.Now()
+	if cmsgs, err := unix.ParseSocketControlMessage(p.oob[:oobn]); err == nil {
+		for _, cmsg := range cmsgs {
+			if cmsg.Header.Level == unix.SOL_SOCKET && cmsg.Header.Type == unix.SCM_TIMESTAMPNS {
+				at = parseTimestamp(cmsg.Data, at)
+			}
+		}
+	}
+
+	message := p.buf[:n]
+	// Raw IPv4 sockets include the IP header
+	if p.raw && !p.ipv6 {
+		if len(message) < 20 {
+			return nil, true, nil
+		}
+		message = message[int(message[0]&0x0f)*4:]
+	}
+
+	r := p.parseICMP(message)
+	if r == nil {
+		return nil, true, nil
+	}
+	if r.from = sockaddrIP(from); r.from == nil {
+		return nil, true, nil
+	}
+	r.at = at
+	return r, true, nil
+}
+
+// parseICMP matches an ICMP message received on the socket to a probe
+func (p *linuxProber) parseICMP(message []byte) *reply {
+	if len(message) < 8 {
+		return nil
+	}
+
+	echoReply, timeExceeded, unreachable := byte(icmpv4EchoReply), byte(icmpv4TimeExceeded), byte(icmpv4Unreachable)
+	if p.ipv6 {
+		echoReply, timeExceeded, unreachable = icmpv6EchoReply, icmpv6TimeExceeded, icmpv6Unreachable
+	}
+
+	switch message[0] {
+	case echoReply:
+		if p.raw && binary.BigEndian.Uint16(message[4:]) != p.id {
+			return nil
+		}
+		return &reply{index: int(binary.BigEndian.Uint16(message[6:])), final: true}
+	case timeExceeded, unreachable:
+		// Only raw sockets see ICMP errors here. They quote the probe after
+		// the ICMP header: its IP header, then the echo request.
+		if !p.raw {
+			return nil
+		}
+		quoted := message[8:]
+		if p.ipv6 {
+			if len(quoted) < 40 {
+				return nil
+			}
+			quoted = quoted[40:]
+		} else {
+			if len(quoted) < 20 {
+				return nil
+			}
+			quoted = quoted[int(quoted[0]&0x0f)*4:]
+		}
+		if len(quoted) < 8 || binary.BigEndian.Uint16(quoted[4:]) != p.id {
+			return nil
+		}
+		return &reply{index: int(binary.BigEndian.Uint16(quoted[6:])), final: message[0] == unreachable}
+	}
+	return nil
+}
+
+// isICMPErrno reports whether err is one of the errors reported by socket
+// calls after an ICMP error was received for an earlier probe
+func isICMPErrno(err error) bool {
+	switch err {
+	case unix.EHOSTUNREACH, unix.ENETUNREACH, unix.ECONNREFUSED, unix.EHOSTDOWN, unix.EPROTO, unix.EACCES:
+		return true
+	}
+	return false
+}
+
+// checksum computes the internet checksum of b
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// parseTimestamp decodes an SCM_TIMESTAMPNS control message
+func parseTimestamp(data []byte, fallback time.Time) time.Time {
+	if len(data) < int(unsafe.Sizeof(unix.Timespec{})) {
+		return fallback
+	}
+	ts := (*unix.Timespec)(unsafe.Pointer(&data[0]))
+	return time.Unix(ts.Unix())
+}
+
+// parseOffender decodes the address that sent an ICMP error, which follows
+// the extended error in IP_RECVERR control messages
+func parseOffender(data []byte) net.IP {
+	if len(data) < 2 {
+		return nil
+	}
+	switch binary.NativeEndian.Uint16(data) {
+	case unix.AF_INET:
+		if len(data) >= unix.SizeofSockaddrInet4 {
+			return net.IP(append([]byte(nil), data[4:8]...))
+		}
+	case unix.AF_INET6:
+		if len(data) >= unix.SizeofSockaddrInet6 {
+			return net.IP(append([]byte(nil), data[8:24]...))
+		}
+	}
+	return nil
+}
+
+// sockaddrIP returns the address of a socket address
+func sockaddrIP(sa unix.Sockaddr) net.IP {
+	switch addr := sa.(type) {
+	case *unix.SockaddrInet4:
+		return net.IP(append([]byte(nil), addr.Addr[:]...))
+	case *unix.SockaddrInet6:
+		return net.IP(append([]byte(nil), addr.Addr[:]...))
+	}
+	return nil
+}
+
+// sockaddrPort returns the port of a socket address, or -1
+func sockaddrPort(sa unix.Sockaddr) int {
+	switch addr := sa.(type) {
+	case *unix.SockaddrInet4:
+		return addr.Port
+	case *unix.SockaddrInet6:
+		return addr.Port
+	}
+	return -1
+}
@@ -0,0 +1,142 @@
+package tracer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"golang.org/x/sys/unix"
+)
+
+func TestNativeLoopback(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		host     string
+		port     int
+	}{
+		{name: "udp ipv4", protocol: config.ProtocolUDP, host: "127.0.0.1"},
+		{name: "udp ipv4 fixed port", protocol: config.ProtocolUDP, host: "127.0.0.1", port: 53},
+		{name: "icmp ipv4", protocol: config.ProtocolICMP, host: "127.0.0.1"},
+		{name: "udp ipv6", protocol: config.ProtocolUDP, host: "::1"},
+		{name: "icmp ipv6", protocol: config.ProtocolICMP, host: "::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			native := NewNative()
+			native.ResolveHostnames = false
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			target := config.Target{Host: tt.host, MaxHops: 5, Protocol: tt.protocol, Port: tt.port, Queries: 2}
+			result, err := native.Trace(ctx, target, nil)
+			if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) ||
+				errors.Is(err, unix.EAFNOSUPPORT) || errors.Is(err, unix.EADDRNOTAVAIL) || errors.Is(err, unix.ENETUNREACH) {
+				t.Skipf("Socket not available in this environment: %v", err)
+			}
+			if err != nil {
+				t.Fatalf("Trace failed: %v", err)
+			}
+
+			if len(result.Hops) != 1 {
+				t.Fatalf("Expected 1 hop to loopback, got %d", len(result.Hops))
+			}
+			hop := result.Hops[0]
+			if hop.TTL != 1 {
+				t.Errorf("Expected TTL 1, got %d", hop.TTL)
+			}
+			if hop.IP != tt.host {
+				t.Errorf("Expected hop IP %s, got %s", tt.host, hop.IP)
+			}
+			if hop.Loss != 0 {
+				t.Errorf("Expected no loss, got %v", hop.Loss)
+			}
+			if len(hop.RTT) != 2 {
+				t.Errorf("Expected 2 RTT samples, got %d", len(hop.RTT))
+			}
+		})
+	}
+}
+
+func TestNativeCancel(t *testing.T) {
+	native := NewNative()
+	native.ResolveHostnames = false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	target := config.Target{Host: "127.0.0.1", MaxHops: 5, Protocol: config.ProtocolUDP}
+	if _, err := native.Trace(ctx, target, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestErrorIndex(t *testing.T) {
+	echo := []byte{icmpv4EchoRequest, 0, 0, 0, 0x12, 0x34, 0, 7}
+
+	tests := []struct {
+		name     string
+		prober   *linuxProber
+		fd       int
+		from     unix.Sockaddr
+		payload  []byte
+		expected int
+	}{
+		{
+			name:     "udp port per probe",
+			prober:   &linuxProber{fd: 3, protocol: config.ProtocolUDP},
+			fd:       3,
+			from:     &unix.SockaddrInet4{Port: baseUDPPort + 5},
+			expected: 5,
+		},
+		{
+			// Routers quoting only 8 bytes leave no UDP payload
+			name:     "udp fixed port truncated quote",
+			prober:   &linuxProber{fd: -1, protocol: config.ProtocolUDP, port: 53, sockets: map[int]int{4: 2, 5: 9}},
+			fd:       5,
+			from:     &unix.SockaddrInet4{Port: 53},
+			payload:  []byte{},
+			expected: 9,
+		},
+		{
+			name:     "udp fixed port unknown socket",
+			prober:   &linuxProber{fd: -1, protocol: config.ProtocolUDP, port: 53, sockets: map[int]int{4: 2}},
+			fd:       6,
+			from:     &unix.SockaddrInet4{Port: 53},
+			expected: -1,
+		},
+		{
+			name:     "icmp",
+			prober:   &linuxProber{fd: 3, protocol: config.ProtocolICMP},
+			fd:       3,
+			payload:  echo,
+			expected: 7,
+		},
+		{
+			name:     "icmp truncated quote",
+			prober:   &linuxProber{fd: 3, protocol: config.ProtocolICMP},
+			fd:       3,
+			payload:  echo[:4],
+			expected: -1,
+		},
+		{
+			name:     "icmp raw socket other identifier",
+			prober:   &linuxProber{fd: 3, protocol: config.ProtocolICMP, raw: true, id: 0x4321},
+			fd:       3,
+			payload:  echo,
+			expected: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if index := tt.prober.errorIndex(tt.fd, tt.from, tt.payload); index != tt.expected {
+				t.Errorf("Expected index %d, got %d", tt.expected, index)
+			}
+		})
+	}
+}
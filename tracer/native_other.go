@@ -0,0 +1,14 @@
+//go:build !linux
+
+package tracer
+
+import (
+	"fmt"
+	"net"
+)
+
+// openProber is only implemented on Linux, which reports the ICMP errors
+// triggered by probes to unprivileged sockets
+func openProber(dst net.IP, protocol string, port int) (prober, error) {
+	return nil, fmt.Errorf("the native backend is only supported on Linux")
+}
@@ -0,0 +1,48 @@
+package tracer
+
+import (
+	"strconv"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// NewNextTrace creates a tracer running the nexttrace binary at binaryPath
+func NewNextTrace(binaryPath string) *Command {
+	return &Command{
+		BinaryPath: binaryPath,
+		Args:       nextTraceArgs,
+		Parse:      parser.ParseNextTraceRawOutput,
+		Stream: func() LineParser {
+			return parser.NewNextTraceRawParser()
+		},
+	}
+}
+
+// nextTraceArgs builds the nexttrace command line for a target
+func nextTraceArgs(target config.Target) []string {
+	// --raw for one line per probe, printed as soon as a TTL is done,
+	// --language en for English locations, -C to disable ANSI color codes,
+	// and -M to disable map upload
+	args := []string{"--raw", "--language", "en", "-C", "-M"}
+
+	switch target.Protocol {
+	case config.ProtocolTCP:
+		args = append(args, "--tcp")
+	case config.ProtocolUDP:
+		args = append(args, "--udp")
+	}
+	if target.Port > 0 {
+		args = append(args, "--port", strconv.Itoa(target.Port))
+	}
+
+	if target.Queries > 0 {
+		args = append(args, "--queries", strconv.Itoa(target.Queries))
+	}
+
+	if target.MaxHops > 0 {
+		args = append(args, "--max-hops", strconv.Itoa(target.MaxHops))
+	}
+
+	return append(args, target.Host)
+}
@@ -0,0 +1,46 @@
+package tracer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+func TestNextTraceArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   config.Target
+		expected []string
+	}{
+		{
+			name:     "icmp default",
+			target:   config.Target{Host: "8.8.8.8", MaxHops: 30, Protocol: config.ProtocolICMP},
+			expected: []string{"--raw", "--language", "en", "-C", "-M", "--max-hops", "30", "8.8.8.8"},
+		},
+		{
+			name:     "tcp with port",
+			target:   config.Target{Host: "example.com", MaxHops: 20, Protocol: config.ProtocolTCP, Port: 443},
+			expected: []string{"--raw", "--language", "en", "-C", "-M", "--tcp", "--port", "443", "--max-hops", "20", "example.com"},
+		},
+		{
+			name:     "custom queries",
+			target:   config.Target{Host: "8.8.8.8", MaxHops: 30, Protocol: config.ProtocolICMP, Queries: 10},
+			expected: []string{"--raw", "--language", "en", "-C", "-M", "--queries", "10", "--max-hops", "30", "8.8.8.8"},
+		},
+		{
+			name:     "udp without port",
+			target:   config.Target{Host: "1.1.1.1", MaxHops: 30, Protocol: config.ProtocolUDP},
+			expected: []string{"--raw", "--language", "en", "-C", "-M", "--udp", "--max-hops", "30", "1.1.1.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := nextTraceArgs(tt.target)
+			if !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("nextTraceArgs() = %v, want %v", args, tt.expected)
+			}
+		})
+	}
+}
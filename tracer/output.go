@@ -0,0 +1,57 @@
+package tracer
+
+// DefaultMaxOutput is the number of bytes kept of each output stream of a
+// trace tool by default
+const DefaultMaxOutput = 1 << 20
+
+// headBuffer keeps the first max bytes written to it and discards the rest.
+// Writes never fail, so the tool isn't blocked or killed by a short write.
+type headBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); len(p) > room {
+		b.buf = append(b.buf, p[:room]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns the bytes kept
+func (b *headBuffer) Bytes() []byte {
+	return b.buf
+}
+
+// tailBuffer keeps the last max bytes written to it, where the error
+// messages of a failing tool usually are
+type tailBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= b.max {
+		b.truncated = b.truncated || n > b.max || len(b.buf) > 0
+		b.buf = append(b.buf[:0], p[n-b.max:]...)
+		return n, nil
+	}
+
+	if drop := len(b.buf) + n - b.max; drop > 0 {
+		b.buf = append(b.buf[:0], b.buf[drop:]...)
+		b.truncated = true
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+// Bytes returns the bytes kept
+func (b *tailBuffer) Bytes() []byte {
+	return b.buf
+}
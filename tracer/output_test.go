@@ -0,0 +1,47 @@
+package tracer
+
+import (
+	"testing"
+)
+
+func TestHeadBuffer(t *testing.T) {
+	b := &headBuffer{max: 8}
+	for _, chunk := range []string{"abc", "defgh", "ij"} {
+		if n, err := b.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Errorf("Expected write of %d bytes to succeed, got %d %v", len(chunk), n, err)
+		}
+	}
+
+	if string(b.Bytes()) != "abcdefgh" || !b.truncated {
+		t.Errorf("Expected truncated head \"abcdefgh\", got %q (truncated %v)", b.Bytes(), b.truncated)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunks    []string
+		expected  string
+		truncated bool
+	}{
+		{name: "fits", chunks: []string{"abc", "def"}, expected: "abcdef"},
+		{name: "exact", chunks: []string{"abcd", "efgh"}, expected: "abcdefgh"},
+		{name: "overflow", chunks: []string{"abcdef", "ghij"}, expected: "cdefghij", truncated: true},
+		{name: "large write", chunks: []string{"ab", "cdefghijkl"}, expected: "efghijkl", truncated: true},
+		{name: "large first write", chunks: []string{"abcdefghij"}, expected: "cdefghij", truncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &tailBuffer{max: 8}
+			for _, chunk := range tt.chunks {
+				if n, err := b.Write([]byte(chunk)); n != len(chunk) || err != nil {
+					t.Errorf("Expected write of %d bytes to succeed, got %d %v", len(chunk), n, err)
+				}
+			}
+			if string(b.Bytes()) != tt.expected || b.truncated != tt.truncated {
+				t.Errorf("Expected %q (truncated %v), got %q (truncated %v)", tt.expected, tt.truncated, b.Bytes(), b.truncated)
+			}
+		})
+	}
+}
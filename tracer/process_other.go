@@ -0,0 +1,9 @@
+//go:build !unix
+
+package tracer
+
+import "os/exec"
+
+// setProcessGroup is a no-op without process groups; cancelling cmd only
+// kills the tool itself
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,75 @@
+//go:build unix
+
+package tracer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+// processGone reports whether a process has exited, counting zombies that
+// are waiting to be reaped by their new parent
+func processGone(pid int) bool {
+	if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+		return true
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := bytes.Fields(stat[bytes.LastIndexByte(stat, ')')+1:])
+	return len(fields) > 0 && string(fields[0]) == "Z"
+}
+
+func TestCommandKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	// The background sleep keeps stdout open after the shell is killed
+	script := writeScript(t, `sleep 30 &
+echo $! > `+pidFile+`
+echo "traceroute to 8.8.8.8, 30 hops max"
+echo "1|192.168.1.1||0.52|||||||0.0000|0.0000"
+wait
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, err := NewNextTrace(script).Trace(ctx, config.Target{Host: "8.8.8.8"}, nil)
+	if err == nil {
+		t.Fatal("Expected error after timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected Trace to return right after the timeout, took %v", elapsed)
+	}
+	if result == nil || len(result.Hops) != 1 {
+		t.Errorf("Expected the completed hop to be kept, got %+v", result)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !processGone(pid) {
+		if time.Now().After(deadline) {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("Expected child process %d to be killed with the tool", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
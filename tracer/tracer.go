@@ -0,0 +1,50 @@
+// Package tracer runs traces to a target with one of the supported backends
+package tracer
+
+import (
+	"context"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// HopFunc receives a hop of a running trace as soon as it is complete
+type HopFunc func(hop parser.Hop)
+
+// Tracer runs a single trace to a target. It must return promptly once ctx
+// is done. Backends that learn hops while tracing pass them to onHop, which
+// may be nil. When a trace fails after some hops completed, those hops are
+// returned along with the error.
+type Tracer interface {
+	Trace(ctx context.Context, target config.Target, onHop HopFunc) (*parser.NextTraceResult, error)
+}
+
+// CommandError is returned when an external trace tool fails
+type CommandError struct {
+	Err    error
+	Stdout []byte // Start of the standard output of the tool
+	Stderr []byte // End of the standard error of the tool
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError is returned when the output of a trace tool can't be parsed
+type ParseError struct {
+	Err    error
+	Stdout []byte // Output that failed to parse
+	Stderr []byte // End of the standard error of the tool
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,48 @@
+package tracer
+
+import (
+	"strconv"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+// NewTraceroute creates a tracer running the traceroute binary at binaryPath
+func NewTraceroute(binaryPath string) *Command {
+	return &Command{
+		BinaryPath: binaryPath,
+		Args:       tracerouteArgs,
+		Parse:      parser.ParseTracerouteOutput,
+	}
+}
+
+// tracerouteArgs builds the traceroute command line for a target
+func tracerouteArgs(target config.Target) []string {
+	// -n to skip reverse DNS lookups
+	args := []string{"-n"}
+
+	switch target.Protocol {
+	case config.ProtocolICMP:
+		args = append(args, "-I")
+	case config.ProtocolTCP:
+		args = append(args, "-T")
+	case config.ProtocolUDP:
+		// Without -U the port is the first of one port per probe
+		if target.Port > 0 {
+			args = append(args, "-U")
+		}
+	}
+	if target.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(target.Port))
+	}
+
+	if target.Queries > 0 {
+		args = append(args, "-q", strconv.Itoa(target.Queries))
+	}
+
+	if target.MaxHops > 0 {
+		args = append(args, "-m", strconv.Itoa(target.MaxHops))
+	}
+
+	return append(args, target.Host)
+}
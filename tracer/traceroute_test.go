@@ -0,0 +1,46 @@
+package tracer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+)
+
+func TestTracerouteArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   config.Target
+		expected []string
+	}{
+		{
+			name:     "icmp",
+			target:   config.Target{Host: "8.8.8.8", MaxHops: 30, Protocol: config.ProtocolICMP},
+			expected: []string{"-n", "-I", "-m", "30", "8.8.8.8"},
+		},
+		{
+			name:     "udp with port per probe",
+			target:   config.Target{Host: "1.1.1.1", MaxHops: 30, Protocol: config.ProtocolUDP, Queries: 2},
+			expected: []string{"-n", "-q", "2", "-m", "30", "1.1.1.1"},
+		},
+		{
+			name:     "udp with fixed port",
+			target:   config.Target{Host: "1.1.1.1", MaxHops: 30, Protocol: config.ProtocolUDP, Port: 53},
+			expected: []string{"-n", "-U", "-p", "53", "-m", "30", "1.1.1.1"},
+		},
+		{
+			name:     "tcp with port",
+			target:   config.Target{Host: "example.com", MaxHops: 20, Protocol: config.ProtocolTCP, Port: 443},
+			expected: []string{"-n", "-T", "-p", "443", "-m", "20", "example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tracerouteArgs(tt.target)
+			if !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("tracerouteArgs() = %v, want %v", args, tt.expected)
+			}
+		})
+	}
+}
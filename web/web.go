@@ -0,0 +1,275 @@
+package web
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/executor"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// UI serves the web interface: a target overview and a page per target with
+// its hop table and route history
+type UI struct {
+	executor    *executor.Executor
+	targets     func() []config.Target
+	metricsPath string
+	templates   *template.Template
+	logger      *slog.Logger
+}
+
+// targetRow is a target in the overview
+type targetRow struct {
+	Target    config.Target
+	HasResult bool
+	Status    string
+	Reason    string
+	Error     string
+	LastRun   time.Time
+	Hops      int
+	Backoff   executor.BackoffState
+}
+
+// hopRow is a line of the hop table
+type hopRow struct {
+	parser.Hop
+	ForwardedLoss float64
+	RateLimited   bool
+	HasRTT        bool
+	Samples       int // Individual RTT samples, required for the median and p90
+	Avg           float64
+	Min           float64
+	Max           float64
+	Median        float64
+	P90           float64
+	StdDev        float64
+}
+
+// routeEntry is an execution in the route timeline
+type routeEntry struct {
+	Result      *executor.ExecutionResult
+	Fingerprint string
+	Hops        []routeHop
+	Changed     bool // Whether the route differs from the previous successful run
+}
+
+// routeHop is a hop of a route in the timeline
+type routeHop struct {
+	TTL     int
+	Key     string // Responder IPs, ASN or "*", see parser.PathKeys
+	Changed bool
+}
+
+// indexPage is the data of the overview page
+type indexPage struct {
+	Title       string
+	MetricsPath string
+	Targets     []targetRow
+}
+
+// targetPage is the data of a target page
+type targetPage struct {
+	Title       string
+	MetricsPath string
+	Target      config.Target
+	Latest      *executor.ExecutionResult
+	Traced      *executor.ExecutionResult // Latest successful execution, shown in the hop table
+	Hops        []hopRow
+	Reached     bool
+	Routes      []routeEntry // Newest first
+}
+
+// templateFuncs are the helpers available in templates
+var templateFuncs = template.FuncMap{
+	"targetURL": func(name string) string {
+		return "/targets/" + url.PathEscape(name)
+	},
+	"ms": func(v float64) string {
+		return fmt.Sprintf("%.2f", v)
+	},
+	"percent": func(v float64) string {
+		return fmt.Sprintf("%.1f%%", v*100)
+	},
+	"timestamp": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"seconds": func(d time.Duration) string {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	},
+}
+
+// New creates the web interface for the results of exec. targets is called
+// on every request to follow reloads.
+func New(exec *executor.Executor, targets func() []config.Target, metricsPath string, logger *slog.Logger) *UI {
+	return &UI{
+		executor:    exec,
+		targets:     targets,
+		metricsPath: metricsPath,
+		templates:   template.Must(template.New("").Funcs(templateFuncs).ParseFS(templateFS, "templates/*.html")),
+		logger:      logger,
+	}
+}
+
+// Register adds the web interface pages to mux
+func (u *UI) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/", u.handleIndex)
+	mux.HandleFunc("/targets/", u.handleTarget)
+}
+
+// handleIndex serves the target overview
+func (u *UI) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	results := u.executor.GetAllResults()
+	page := indexPage{MetricsPath: u.metricsPath}
+	for _, target := range u.targets() {
+		row := targetRow{Target: target}
+		if result, exists := results[target.Name]; exists {
+			row.HasResult = true
+			row.Status = result.Status
+			row.Reason = result.Reason
+			row.LastRun = result.Timestamp
+			if result.Error != nil {
+				row.Error = result.Error.Error()
+			}
+			if result.Result != nil {
+				row.Hops = len(result.Result.Hops)
+			}
+		}
+		row.Backoff, _ = u.executor.GetBackoffState(target.Name)
+		page.Targets = append(page.Targets, row)
+	}
+
+	u.render(w, "index.html", page)
+}
+
+// handleTarget serves the page of a single target
+func (u *UI) handleTarget(w http.ResponseWriter, r *http.Request) {
+	name, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/targets/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var (
+		target config.Target
+		found  bool
+	)
+	for _, t := range u.targets() {
+		if t.Name == name {
+			target, found = t, true
+			break
+		}
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := targetPage{
+		Title:       target.Name,
+		MetricsPath: u.metricsPath,
+		Target:      target,
+	}
+	page.Latest, _ = u.executor.GetResult(name)
+
+	history := u.executor.GetHistory(name)
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Result != nil {
+			page.Traced = history[i]
+			break
+		}
+	}
+	if page.Traced != nil {
+		result := page.Traced.Result
+		forwarded := result.ForwardedLoss()
+		for i, hop := range result.Hops {
+			page.Hops = append(page.Hops, newHopRow(hop, forwarded[i]))
+		}
+		_, _, page.Reached = result.Destination()
+	}
+	page.Routes = routeTimeline(history)
+
+	u.render(w, "target.html", page)
+}
+
+// newHopRow computes the hop table line of hop
+func newHopRow(hop parser.Hop, forwardedLoss float64) hopRow {
+	return hopRow{
+		Hop:           hop,
+		ForwardedLoss: forwardedLoss,
+		RateLimited:   hop.HasValidIP() && hop.Loss > forwardedLoss,
+		HasRTT:        hop.HasRTT(),
+		Samples:       len(hop.RTT),
+		Avg:           hop.AverageRTT(),
+		Min:           hop.MinRTT(),
+		Max:           hop.MaxRTT(),
+		Median:        hop.MedianRTT(),
+		P90:           hop.PercentileRTT(90),
+		StdDev:        hop.StdDevRTT(),
+	}
+}
+
+// routeTimeline builds the route timeline from a target's history, oldest
+// first, marking the hops that changed since the previous successful run.
+// The timeline is returned newest first.
+func routeTimeline(history []*executor.ExecutionResult) []routeEntry {
+	entries := make([]routeEntry, 0, len(history))
+	var previous *parser.NextTraceResult
+
+	for _, result := range history {
+		entry := routeEntry{Result: result}
+		if result.Result != nil {
+			// Partial traces are shown but not compared, like in the executor
+			complete := result.Status == executor.StatusSuccess
+			changed := make(map[int]bool)
+			path := result.Result
+			if previous != nil && complete {
+				// Hops that didn't answer are assumed unchanged, like in the
+				// executor
+				path = parser.MergeSilentHops(previous, path)
+				for _, ttl := range parser.ChangedTTLs(previous, path) {
+					changed[ttl] = true
+				}
+			}
+			entry.Changed = len(changed) > 0
+			entry.Fingerprint = path.Fingerprint()
+
+			// Path keys map one to one onto the leading hops
+			for i, key := range result.Result.PathKeys() {
+				ttl := result.Result.Hops[i].TTL
+				entry.Hops = append(entry.Hops, routeHop{TTL: ttl, Key: key, Changed: changed[ttl]})
+			}
+			if complete {
+				previous = path
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// render executes a page template
+func (u *UI) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := u.templates.ExecuteTemplate(w, name, data); err != nil {
+		u.logger.Error("Failed to render page", "template", name, "error", err)
+	}
+}
@@ -0,0 +1,180 @@
+package web
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinsec/nexttrace_exporter/config"
+	"github.com/vinsec/nexttrace_exporter/executor"
+	"github.com/vinsec/nexttrace_exporter/parser"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *executor.Executor) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	exec := executor.NewExecutor("nexttrace", time.Minute, logger)
+
+	targets := []config.Target{
+		{Name: "google_dns", Host: "8.8.8.8", Protocol: config.ProtocolICMP, Backend: config.BackendNextTrace, Interval: 5 * time.Minute, MaxHops: 30},
+		{Name: "dc/fra1", Host: "1.1.1.1", Protocol: config.ProtocolTCP, Port: 443, Backend: config.BackendNextTrace, Interval: time.Minute, MaxHops: 30},
+	}
+
+	mux := http.NewServeMux()
+	New(exec, func() []config.Target { return targets }, "/metrics", logger).Register(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, exec
+}
+
+// getPage requests path and returns the status code and body
+func getPage(t *testing.T, server *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response of %s: %v", path, err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestIndex(t *testing.T) {
+	server, exec := newTestServer(t)
+	exec.SetTestResult("google_dns", &parser.NextTraceResult{
+		Target: "8.8.8.8",
+		Hops: []parser.Hop{
+			{TTL: 1, IP: "192.168.1.1", RTT: []float64{1.5}},
+			{TTL: 2, IP: "8.8.8.8", RTT: []float64{10.2}},
+		},
+	}, time.Second)
+	exec.SetTestFailure("dc/fra1", executor.StatusError, executor.ReasonDNSFailure)
+
+	status, body := getPage(t, server, "/")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+
+	for _, want := range []string{
+		`href="/targets/google_dns"`,
+		`href="/targets/dc%2Ffra1"`,
+		`class="status-success"`,
+		`class="status-error"`,
+		executor.ReasonDNSFailure,
+		`href="/metrics"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected overview to contain %q", want)
+		}
+	}
+
+	if status, _ := getPage(t, server, "/unknown"); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown path, got %d", status)
+	}
+}
+
+func TestTargetPage(t *testing.T) {
+	server, exec := newTestServer(t)
+
+	route := func(second string) *parser.NextTraceResult {
+		return &parser.NextTraceResult{
+			Target:   "8.8.8.8",
+			TargetIP: "8.8.8.8",
+			Hops: []parser.Hop{
+				{TTL: 1, IP: "192.168.1.1", Hostname: "gateway.local", RTT: []float64{1.0, 2.0, 3.0}},
+				{TTL: 2, IP: second, ASN: "15169", ASName: "GOOGLE", Location: "Mountain View, United States", RTT: []float64{9.0, 11.0}, Loss: 0.5},
+				{TTL: 3, IP: "8.8.8.8", RTT: []float64{10.0}, Responders: []parser.Responder{{IP: "8.8.8.8", RTT: []float64{10.0}}}},
+			},
+		}
+	}
+	exec.SetTestResult("google_dns", route("10.0.0.1"), time.Second)
+	exec.SetTestResult("google_dns", route("10.0.0.2"), time.Second)
+	exec.SetTestFailure("google_dns", executor.StatusTimeout, executor.ReasonTimeout)
+
+	status, body := getPage(t, server, "/targets/google_dns")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+
+	for _, want := range []string{
+		"gateway.local",
+		"AS15169",
+		"GOOGLE",
+		"Mountain View, United States",
+		"50.0%",                                 // Loss of hop 2
+		`<td class="num">2.00</td>`,             // Average RTT of hop 1
+		"destination reached",                   // From the last successful trace
+		`class="status-timeout"`,                // Latest execution
+		`title="Changed at hop 2">10.0.0.2`,     // Route change highlighted
+		`action="/-/trigger?target=google_dns"`, // Run now button
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected target page to contain %q", want)
+		}
+	}
+	if strings.Contains(body, `title="Changed at hop 2">10.0.0.1`) {
+		t.Error("Expected the first route not to be highlighted")
+	}
+
+	// Escaped slashes are part of the name
+	if status, body := getPage(t, server, "/targets/dc%2Ffra1"); status != http.StatusOK || !strings.Contains(body, "No successful trace yet") {
+		t.Errorf("Expected empty page for dc/fra1, got %d", status)
+	}
+	if status, _ := getPage(t, server, "/targets/unknown"); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown target, got %d", status)
+	}
+}
+
+func TestRouteTimeline(t *testing.T) {
+	result := func(ips ...string) *executor.ExecutionResult {
+		r := &parser.NextTraceResult{}
+		for i, ip := range ips {
+			r.Hops = append(r.Hops, parser.Hop{TTL: i + 1, IP: ip})
+		}
+		return &executor.ExecutionResult{Result: r, Status: executor.StatusSuccess}
+	}
+
+	history := []*executor.ExecutionResult{
+		result("10.0.0.1", "10.0.1.1"),
+		{Status: executor.StatusError, Reason: executor.ReasonUnknown},
+		result("10.0.0.1", "10.0.2.1"),
+		result("10.0.0.1", "10.0.2.1", ""),
+	}
+
+	entries := routeTimeline(history)
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(entries))
+	}
+
+	// Newest first; failures are skipped when comparing routes
+	tests := []struct {
+		changed bool
+		hops    int
+		second  bool // Whether the second hop is highlighted
+	}{
+		{changed: false, hops: 2},              // Trailing silent hop dropped
+		{changed: true, hops: 2, second: true}, // Changed since the first run
+		{changed: false, hops: 0},              // Failure
+		{changed: false, hops: 2},              // First run
+	}
+	for i, tt := range tests {
+		entry := entries[i]
+		if entry.Changed != tt.changed {
+			t.Errorf("Entry %d: expected changed %v, got %v", i, tt.changed, entry.Changed)
+		}
+		if len(entry.Hops) != tt.hops {
+			t.Fatalf("Entry %d: expected %d hops, got %d", i, tt.hops, len(entry.Hops))
+		}
+		if tt.hops > 0 && entry.Hops[1].Changed != tt.second {
+			t.Errorf("Entry %d: expected second hop changed %v, got %v", i, tt.second, entry.Hops[1].Changed)
+		}
+	}
+}